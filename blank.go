@@ -0,0 +1,89 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+// BlankOptions controls blank page detection.
+type BlankOptions struct {
+	Margin    float64 // fraction of each side ignored, to skip borders and shadows
+	Threshold float64 // darkness relative to the paper for a sample to count as ink, from 0 to 1
+	Coverage  float64 // largest fraction of ink samples in a blank page, from 0 to 1
+}
+
+// DefaultBlankOptions are reasonable settings for office documents. They
+// tolerate scanner noise, specks of dust and textured or tinted paper.
+var DefaultBlankOptions = BlankOptions{
+	Margin:    0.05,
+	Threshold: 0.25,
+	Coverage:  0.002,
+}
+
+// IsBlank reports whether the frame is blank. Each channel is considered
+// separately, and the frame is blank only if all of them are. Infrared
+// channels are ignored.
+//
+// The paper level of a channel is taken to be its brightest percentile, but
+// no darker than mid-gray, so that a page that is mostly or wholly dark is
+// not mistaken for dark paper. A sample counts as ink if it is darker than
+// the paper by more than opts.Threshold.
+func (f *Frame) IsBlank(opts BlankOptions) bool {
+	mx := int(float64(f.Width) * opts.Margin)
+	my := int(float64(f.Height) * opts.Margin)
+	if 2*mx >= f.Width || 2*my >= f.Height {
+		return true // nothing left to look at
+	}
+	total := (f.Width - 2*mx) * (f.Height - 2*my)
+	max := (1 << uint(f.Depth)) - 1
+	for ch := 0; ch < f.colorChannels(); ch++ {
+		// Build an 8-bit histogram of the channel.
+		var hist [256]int
+		for y := my; y < f.Height-my; y++ {
+			for x := mx; x < f.Width-mx; x++ {
+				hist[int(f.At(x, y, ch))*255/max]++
+			}
+		}
+		// Find the brightest percentile.
+		paper, n := 255, 0
+		for ; paper > 0; paper-- {
+			if n += hist[paper]; 100*n >= total {
+				break
+			}
+		}
+		if paper < 128 {
+			paper = 128
+		}
+		// Count the ink.
+		ink := 0
+		for v := 0; v < 256 && float64(paper-v) > 255*opts.Threshold; v++ {
+			ink += hist[v]
+		}
+		if float64(ink) > float64(total)*opts.Coverage {
+			return false
+		}
+	}
+	return true
+}
+
+// IsBlank reports whether the image is blank. See Frame.IsBlank for details.
+func (m *Image) IsBlank(opts BlankOptions) bool {
+	for _, f := range m.frames() {
+		if f != nil && !f.IsBlank(opts) {
+			return false
+		}
+	}
+	return true
+}
+
+// DropBlank filters out the blank images in ms. It returns the remaining images
+// and the indices in ms of the ones removed.
+func DropBlank(ms []*Image, opts BlankOptions) (kept []*Image, removed []int) {
+	for i, m := range ms {
+		if m.IsBlank(opts) {
+			removed = append(removed, i)
+		} else {
+			kept = append(kept, m)
+		}
+	}
+	return kept, removed
+}
@@ -0,0 +1,108 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+// A ButtonEvent reports a change in the value of a sensor option, such as
+// the press of a scan button.
+type ButtonEvent struct {
+	Name  string      // option name
+	Value interface{} // new value
+	Old   interface{} // previous value
+}
+
+// Pressed reports whether the event is a press: a bool option becoming true,
+// or an int option becoming non-zero. Some backends report the function
+// selected on the device's display as an int, which changes without a press.
+func (e ButtonEvent) Pressed() bool {
+	switch v := e.Value.(type) {
+	case bool:
+		return v
+	case int:
+		return v != 0
+	}
+	return false
+}
+
+// Sensors returns the active options that can be read but not set, which
+// report the state of the device's buttons and sensors, such as scan, email,
+// cover-open or page-loaded.
+func (c *Conn) Sensors() []Option {
+	var opts []Option
+	for _, o := range c.Options() {
+		if o.IsActive && o.IsDetectable && !o.IsSettable && hasValue(o.Type) {
+			opts = append(opts, o)
+		}
+	}
+	return opts
+}
+
+// readSensor reads a sensor option without recording its value, so that
+// polling does not affect OptionsDiff.
+func (c *Conn) readSensor(o *Option) (interface{}, error) {
+	return c.readValue(o, make([]byte, o.size))
+}
+
+// WatchButtons polls the sensor options returned by Sensors at the given
+// interval, and sends an event on the returned channel whenever one of them
+// changes. The values read on the first poll serve as a baseline and produce
+// no events, so a button held down when watching starts is not reported.
+// Sensors that cannot be read on a poll are skipped until they can.
+//
+// The connection is polled from another goroutine, so it must not be used
+// until ctx is done and the channel is closed. To scan in response to a
+// press, stop watching first; scanning in the meantime would race with the
+// polls.
+func (c *Conn) WatchButtons(ctx context.Context, interval time.Duration) (<-chan ButtonEvent, error) {
+	sensors := c.Sensors()
+	if len(sensors) == 0 {
+		return nil, errors.New("sane: device has no buttons or sensors")
+	}
+	last := make(map[string]interface{})
+	for _, o := range sensors {
+		v, err := c.readSensor(&o)
+		if err != nil {
+			return nil, err
+		}
+		last[o.Name] = v
+	}
+
+	ch := make(chan ButtonEvent)
+	go func() {
+		defer close(ch)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			for _, o := range sensors {
+				v, err := c.readSensor(&o)
+				if err != nil {
+					continue
+				}
+				old := last[o.Name]
+				last[o.Name] = v
+				if reflect.DeepEqual(v, old) {
+					continue
+				}
+				select {
+				case ch <- ButtonEvent{o.Name, v, old}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,150 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"reflect"
+	"sort"
+)
+
+// An OptionChange describes how an option was affected by setting other
+// options.
+type OptionChange struct {
+	Name        string // option name
+	Added       bool   // option appeared
+	Removed     bool   // option disappeared
+	Activated   bool   // option became active
+	Deactivated bool   // option became inactive
+	Constraint  bool   // constraint, unit, size or capabilities changed
+	Value       bool   // value changed
+}
+
+// sameDescriptor reports whether two descriptors of an option are the same,
+// disregarding whether the option is active.
+func sameDescriptor(a, b Option) bool {
+	a.IsActive, b.IsActive = false, false
+	a.index, b.index = 0, 0
+	return reflect.DeepEqual(a, b)
+}
+
+// change returns the pending change record for the named option.
+func (c *Conn) change(name string) *OptionChange {
+	if c.changes == nil {
+		c.changes = make(map[string]*OptionChange)
+	}
+	ch, ok := c.changes[name]
+	if !ok {
+		ch = &OptionChange{Name: name}
+		c.changes[name] = ch
+	}
+	return ch
+}
+
+// reload is called after setting the named option caused the options to be
+// reloaded. It compares the old options, and the values known to the caller,
+// to the new ones, recording the differences and the dependencies of the
+// affected options on the one that was set.
+func (c *Conn) reload(name string, old []Option) {
+	c.options = nil
+	cur := c.Options()
+	affected := make(map[string]bool)
+
+	prev := make(map[string]Option)
+	for _, o := range old {
+		prev[o.Name] = o
+	}
+	for _, o := range cur {
+		p, ok := prev[o.Name]
+		delete(prev, o.Name)
+		switch {
+		case !ok:
+			c.change(o.Name).Added = true
+		case o.IsActive && !p.IsActive:
+			c.change(o.Name).Activated = true
+		case !o.IsActive && p.IsActive:
+			c.change(o.Name).Deactivated = true
+		}
+		if ok && !sameDescriptor(o, p) {
+			c.change(o.Name).Constraint = true
+		}
+		if !ok || o.IsActive != p.IsActive || !sameDescriptor(o, p) {
+			affected[o.Name] = true
+		}
+	}
+	for n := range prev {
+		c.change(n).Removed = true
+		affected[n] = true
+		delete(c.known, n)
+	}
+
+	// Check the values the caller has seen.
+	for n, v := range c.known {
+		if n == name {
+			continue
+		}
+		nv, err := c.GetOption(n)
+		if err != nil {
+			delete(c.known, n) // most likely inactive now
+		}
+		if err != nil || !reflect.DeepEqual(nv, v) {
+			c.change(n).Value = true
+			affected[n] = true
+		}
+	}
+
+	delete(affected, name)
+	if len(affected) == 0 {
+		return
+	}
+	if c.deps == nil {
+		c.deps = make(map[string]map[string]bool)
+	}
+	if c.deps[name] == nil {
+		c.deps[name] = make(map[string]bool)
+	}
+	for n := range affected {
+		c.deps[name][n] = true
+	}
+}
+
+// OptionsDiff returns the options affected by setting other options since
+// the last call to OptionsDiff, sorted by name. Value changes are only
+// reported for options whose value was previously read with GetOption.
+//
+// Frontends can call OptionsDiff whenever SetOption reports Info.ReloadOpts,
+// and refresh only the affected options.
+func (c *Conn) OptionsDiff() []OptionChange {
+	var changes []OptionChange
+	for _, ch := range c.changes {
+		changes = append(changes, *ch)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	c.changes = nil
+	return changes
+}
+
+// Dependents returns the names of the options that have so far been
+// observed to change when the named option is set, sorted by name. The
+// dependency graph is inferred as options are set, so it is incomplete until
+// every option has been set to every relevant value.
+func (c *Conn) Dependents(name string) []string {
+	var names []string
+	for n := range c.deps[name] {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DependencyGraph returns the inferred dependencies between options, as a
+// map from the name of each option to the result of Dependents for it.
+// Options with no known dependents are omitted.
+func (c *Conn) DependencyGraph() map[string][]string {
+	g := make(map[string][]string)
+	for name := range c.deps {
+		g[name] = c.Dependents(name)
+	}
+	return g
+}
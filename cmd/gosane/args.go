@@ -0,0 +1,188 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// config holds the settings given on the command line.
+type config struct {
+	device         string   // device name, or empty for the default device
+	list           bool     // list devices
+	all            bool     // list device options
+	help           bool     // print help
+	json           bool     // print devices and options as JSON
+	dontScan       bool     // set options without scanning
+	interactive    bool     // browse and set options interactively
+	sixel          bool     // render previews as sixel graphics
+	profile        string   // profile to apply, and to save to interactively
+	progress       bool     // print progress
+	waitPaper      bool     // wait for paper before a batch scan
+	format         string   // output format, or empty to infer it
+	output         string   // output file, or empty for standard output
+	batch          bool     // scan in batch mode
+	pattern        string   // batch file name pattern, or empty for the default
+	batchStart     int      // number of the first batch page
+	batchCount     int      // maximum number of batch pages, or -1 for no limit
+	batchIncrement int      // increment of the batch page number
+	opts           []optArg // device options, in order
+}
+
+// An optArg is a device option given on the command line.
+type optArg struct {
+	name     string // option name, without dashes
+	value    string // option value
+	hasValue bool   // whether a value was given
+}
+
+// A flagSpec describes a command-line option of the program itself.
+type flagSpec struct {
+	short string                          // one-letter name, or empty
+	long  string                          // long name
+	arg   string                          // name of the argument, or empty for none; in brackets if optional
+	help  string                          // description
+	set   func(c *config, v string) error // applies the option
+}
+
+func setInt(p *int) func(c *config, v string) error {
+	return func(c *config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("not an integer: %s", v)
+		}
+		*p = n
+		return nil
+	}
+}
+
+// flags returns the command-line options of the program, which are those of
+// scanimage, with a few additions.
+func flags(cfg *config) []flagSpec {
+	return []flagSpec{
+		{"d", "device-name", "DEVICE", "use the given scanner device",
+			func(c *config, v string) error { c.device = v; return nil }},
+		{"L", "list-devices", "", "show available scanner devices",
+			func(c *config, v string) error { c.list = true; return nil }},
+		{"A", "all-options", "", "list all available backend options",
+			func(c *config, v string) error { c.all = true; return nil }},
+		{"", "json", "", "with -L or -A, print JSON instead of text",
+			func(c *config, v string) error { c.json = true; return nil }},
+		{"h", "help", "", "display this help message and exit",
+			func(c *config, v string) error { c.help = true; return nil }},
+		{"o", "output-file", "PATH", "save output to the given file instead of stdout",
+			func(c *config, v string) error { c.output = v; return nil }},
+		{"", "format", "pnm|tiff|png|jpeg|pdf", "file format of output file",
+			func(c *config, v string) error {
+				if _, ok := formats[v]; !ok {
+					return fmt.Errorf("unsupported format %s", v)
+				}
+				c.format = v
+				return nil
+			}},
+		{"b", "batch", "[FORMAT]", "working in batch mode, FORMAT is `out%d.pnm' or `out%d.tif' by default depending on --format; without %d, pdf and tiff write all pages to one file",
+			func(c *config, v string) error { c.batch, c.pattern = true, v; return nil }},
+		{"", "batch-start", "#", "page number to start naming files with",
+			setInt(&cfg.batchStart)},
+		{"", "batch-count", "#", "how many pages to scan in batch mode",
+			setInt(&cfg.batchCount)},
+		{"", "batch-increment", "#", "increase page number in filename by #",
+			setInt(&cfg.batchIncrement)},
+		{"", "wait-for-paper", "", "in batch mode, wait for paper to be placed in the feeder",
+			func(c *config, v string) error { c.waitPaper = true; return nil }},
+		{"", "profile", "FILE", "apply the option values saved in FILE; in interactive mode, save them there by default",
+			func(c *config, v string) error { c.profile = v; return nil }},
+		{"i", "interactive", "", "browse and set the device options interactively",
+			func(c *config, v string) error { c.interactive = true; return nil }},
+		{"", "sixel", "", "in interactive mode, show previews as sixel graphics instead of text",
+			func(c *config, v string) error { c.sixel = true; return nil }},
+		{"p", "progress", "", "print progress messages",
+			func(c *config, v string) error { c.progress = true; return nil }},
+		{"n", "dont-scan", "", "only set options, don't actually scan",
+			func(c *config, v string) error { c.dontScan = true; return nil }},
+	}
+}
+
+// negative matches negative numbers, which can be option values.
+var negative = regexp.MustCompile(`^-[0-9.]`)
+
+// parseArgs parses the command line. Options that are not options of the
+// program are device options, given as --name, --name=value or --name value,
+// or with a single dash for one-letter names such as -x. For compatibility,
+// device options with longer names may also be given with a single dash.
+func parseArgs(args []string) (*config, error) {
+	cfg := &config{batchStart: 1, batchCount: -1, batchIncrement: 1}
+	specs := flags(cfg)
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if len(a) < 2 || a[0] != '-' || a == "--" {
+			return nil, fmt.Errorf("unexpected argument %s", a)
+		}
+		name := strings.TrimLeft(a, "-")
+		value, hasValue := "", false
+		if j := strings.Index(name, "="); j >= 0 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+
+		var spec *flagSpec
+		for k := range specs {
+			s := &specs[k]
+			if (strings.HasPrefix(a, "--") && name == s.long) || (!strings.HasPrefix(a, "--") && name == s.short) {
+				spec = s
+			}
+		}
+		if spec == nil {
+			// A device option, whose type is not known yet. Take the next
+			// argument as its value unless it looks like an option.
+			if !hasValue && i+1 < len(args) && (!strings.HasPrefix(args[i+1], "-") || negative.MatchString(args[i+1])) {
+				i++
+				value, hasValue = args[i], true
+			}
+			cfg.opts = append(cfg.opts, optArg{name, value, hasValue})
+			continue
+		}
+		switch {
+		case spec.arg == "" && hasValue:
+			return nil, fmt.Errorf("option %s takes no argument", strings.SplitN(a, "=", 2)[0])
+		case spec.arg != "" && !strings.HasPrefix(spec.arg, "[") && !hasValue:
+			if i+1 == len(args) {
+				return nil, fmt.Errorf("option %s requires an argument", a)
+			}
+			i++
+			value = args[i]
+		}
+		if err := spec.set(cfg, value); err != nil {
+			return nil, fmt.Errorf("option %s: %v", a, err)
+		}
+	}
+	return cfg, nil
+}
+
+// printFlags prints the help text for the options of the program.
+func printFlags(w io.Writer) {
+	for _, s := range flags(&config{}) {
+		names := "    "
+		if s.short != "" {
+			names = "-" + s.short + ", "
+		}
+		names += "--" + s.long
+		switch {
+		case strings.HasPrefix(s.arg, "["):
+			names += "[=" + s.arg[1:]
+		case s.arg != "":
+			names += "=" + s.arg
+		}
+		if len(names) > 28 {
+			fmt.Fprintf(w, "%s\n%30s", names, "")
+		} else {
+			fmt.Fprintf(w, "%-30s", names)
+		}
+		fmt.Fprint(w, wrap(s.help, 30, 78)[30:])
+	}
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tjgq/sane"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// errDiffer is returned by runDiff if the compared settings differ.
+var errDiffer = errors.New("settings differ")
+
+// A side is one of the two things being compared: a device or a profile.
+type side struct {
+	name string                 // device name or profile file name
+	opts []sane.Option          // option descriptors, or nil for a profile
+	vals map[string]interface{} // values of the active, settable options
+}
+
+// loadSide reads the options of a device, or a profile if arg ends in .json.
+func loadSide(arg string) (*side, error) {
+	if strings.HasSuffix(strings.ToLower(arg), ".json") {
+		p, err := readProfile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &side{name: arg, vals: p}, nil
+	}
+	c, err := openDevice(arg)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	p, err := c.Profile()
+	if err != nil {
+		return nil, err
+	}
+	return &side{name: c.Device, opts: c.Options(), vals: p}, nil
+}
+
+// find returns the descriptor of the named option, or nil.
+func (s *side) find(name string) *sane.Option {
+	for i := range s.opts {
+		if s.opts[i].Name == name {
+			return &s.opts[i]
+		}
+	}
+	return nil
+}
+
+// has reports whether the option exists on the side. A profile is taken to
+// have the options it sets.
+func (s *side) has(name string) bool {
+	if s.opts == nil {
+		_, ok := s.vals[name]
+		return ok
+	}
+	return s.find(name) != nil
+}
+
+// A difference is an option that differs between two sides.
+type difference struct {
+	Name  string      `json:"name"`  // option name
+	Kind  string      `json:"kind"`  // "missing", "descriptor" or "value"
+	Left  interface{} `json:"left"`  // descriptor, or value if Kind is "value"; null if missing or inactive
+	Right interface{} `json:"right"` // same for the right side
+}
+
+// compare returns the differences between two sides, at most one of which
+// is a profile. Options present on only one side and options with different
+// descriptors are reported first, followed by options with different values.
+// If a side is a profile, only the options it sets are compared, since
+// applying it leaves the others alone.
+func compare(a, b *side) []difference {
+	var names []string
+	if a.opts != nil && b.opts != nil {
+		seen := make(map[string]bool)
+		for _, s := range []*side{a, b} {
+			for _, o := range s.opts {
+				if !seen[o.Name] {
+					seen[o.Name] = true
+					names = append(names, o.Name)
+				}
+			}
+		}
+	} else {
+		p := a.vals
+		if b.opts == nil {
+			p = b.vals
+		}
+		for name := range p {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	// describe returns the descriptor of an option, or its value for a
+	// profile.
+	describe := func(s *side, name string) interface{} {
+		if o := s.find(name); o != nil {
+			return o
+		}
+		return s.vals[name]
+	}
+	var ds []difference
+	for _, name := range names {
+		if !a.has(name) || !b.has(name) {
+			d := difference{Name: name, Kind: "missing"}
+			if a.has(name) {
+				d.Left = describe(a, name)
+			} else {
+				d.Right = describe(b, name)
+			}
+			ds = append(ds, d)
+			continue
+		}
+		if oa, ob := a.find(name), b.find(name); oa != nil && ob != nil && !sameDescriptor(*oa, *ob) {
+			ds = append(ds, difference{name, "descriptor", oa, ob})
+		}
+	}
+	for _, name := range names {
+		if !a.has(name) || !b.has(name) {
+			continue
+		}
+		va, oka := a.vals[name]
+		vb, okb := b.vals[name]
+		if oka != okb || oka && !sameValue(va, vb) {
+			ds = append(ds, difference{name, "value", va, vb})
+		}
+	}
+	return ds
+}
+
+// sameValue reports whether two option values are equal. Numbers are
+// compared by value, so that an int read from a device equals the float64
+// decoded from a profile, and arrays are compared element by element.
+func sameValue(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	ra, rb := reflect.ValueOf(a), reflect.ValueOf(b)
+	if ra.Kind() == reflect.Slice && rb.Kind() == reflect.Slice {
+		if ra.Len() != rb.Len() {
+			return false
+		}
+		for i := 0; i < ra.Len(); i++ {
+			if !sameValue(ra.Index(i).Interface(), rb.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// sameDescriptor reports whether two option descriptors are the same,
+// disregarding whether the options are active, which shows in their values.
+func sameDescriptor(a, b sane.Option) bool {
+	a.IsActive, b.IsActive = false, false
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+// printDiff prints the differences between sides a and b as text.
+func printDiff(w io.Writer, a, b *side, ds []difference) {
+	show := func(v interface{}) string {
+		if v == nil {
+			return "inactive"
+		}
+		return formatValue(v)
+	}
+	for _, d := range ds {
+		switch d.Kind {
+		case "missing":
+			where := a.name
+			if d.Right != nil {
+				where = b.name
+			}
+			fmt.Fprintf(w, "%s: only in %s\n", d.Name, where)
+		case "descriptor":
+			oa, ob := d.Left.(*sane.Option), d.Right.(*sane.Option)
+			ca, cb := constraints(oa), constraints(ob)
+			if ca == cb {
+				fmt.Fprintf(w, "%s: descriptors differ\n", d.Name)
+			} else {
+				fmt.Fprintf(w, "%s: %s in %s, %s in %s\n", d.Name, ca, a.name, cb, b.name)
+			}
+		case "value":
+			fmt.Fprintf(w, "%s: %s in %s, %s in %s\n", d.Name, show(d.Left), a.name, show(d.Right), b.name)
+		}
+	}
+}
+
+// runDiff runs the diff subcommand with the given arguments.
+func runDiff(args []string) error {
+	var names []string
+	asJSON := false
+	for _, a := range args {
+		if a == "--json" {
+			asJSON = true
+		} else {
+			names = append(names, a)
+		}
+	}
+	if len(names) != 2 {
+		return fmt.Errorf("diff takes two devices or profiles (FILE.json); try %s --help", progName)
+	}
+	var sides [2]*side
+	for i, name := range names {
+		s, err := loadSide(name)
+		if err != nil {
+			return err
+		}
+		sides[i] = s
+	}
+	if sides[0].opts == nil && sides[1].opts == nil {
+		return fmt.Errorf("diff needs at least one device")
+	}
+	ds := compare(sides[0], sides[1])
+	if asJSON {
+		if ds == nil {
+			ds = []difference{}
+		}
+		if err := writeJSON(os.Stdout, ds); err != nil {
+			return err
+		}
+	} else {
+		printDiff(os.Stdout, sides[0], sides[1], ds)
+	}
+	if len(ds) > 0 {
+		return errDiffer
+	}
+	return nil
+}
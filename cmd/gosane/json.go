@@ -0,0 +1,93 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/tjgq/sane"
+	"io"
+	"os"
+)
+
+// writeJSON writes v as indented JSON.
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// listDevicesJSON prints the available devices as a JSON array.
+func listDevicesJSON(w io.Writer) error {
+	devs, err := sane.Devices()
+	if err != nil {
+		return err
+	}
+	if devs == nil {
+		devs = []sane.Device{}
+	}
+	return writeJSON(w, devs)
+}
+
+// withValue encodes the descriptor of o with an extra value field, which is
+// null for inactive options and options without a value.
+func withValue(o sane.Option, v interface{}) (json.RawMessage, error) {
+	b, err := json.Marshal(o)
+	if err != nil {
+		return nil, err
+	}
+	if v == sane.Inactive {
+		v = nil
+	}
+	val, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.TrimSuffix(b, []byte("}"))
+	b = append(b, `,"value":`...)
+	b = append(b, val...)
+	return append(b, '}'), nil
+}
+
+// printOptionsJSON prints the descriptors and current values of the options
+// of c as a JSON object.
+func printOptionsJSON(w io.Writer, c *sane.Conn) error {
+	vals := c.Values()
+	opts := []json.RawMessage{}
+	for _, o := range c.Options() {
+		b, err := withValue(o, vals[o.Name])
+		if err != nil {
+			return err
+		}
+		opts = append(opts, b)
+	}
+	return writeJSON(w, struct {
+		Device  string            `json:"device"`
+		Options []json.RawMessage `json:"options"`
+	}{c.Device, opts})
+}
+
+// readProfile reads a profile saved as a JSON object of option values.
+func readProfile(name string) (sane.Profile, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var p sane.Profile
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return p, nil
+}
+
+// writeProfile writes p to the named file as JSON.
+func writeProfile(name string, p sane.Profile) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(b, '\n'), 0644)
+}
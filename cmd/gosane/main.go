@@ -0,0 +1,259 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Gosane scans images with SANE devices.
+//
+// It accepts the command-line options of the scanimage utility shipped with
+// SANE, and produces the same output for -L and -A:
+//
+//	gosane -L
+//	gosane -d test -A
+//	gosane -d test --mode Color --resolution 300 -x 210mm -y 297mm -o page.png
+//	gosane -d test --source=ADF --batch=page%d.jpg --progress
+//	gosane -d test --batch=doc.pdf
+//
+// Device options are given after one dash if their name has one letter, and
+// after two dashes otherwise. Numeric values may have a unit, such as 210mm,
+// 8.5in or 100ms, and options holding several values take a comma-separated
+// list or the vector syntax of scanimage, such as [0]0-[255]255. Run with -h
+// and a device to list its options.
+//
+// The subcommands list, show and scan are shorthands for -L, -d DEVICE -A and
+// -d DEVICE -o FILE:
+//
+//	gosane list
+//	gosane show <device-name>
+//	gosane scan <device-name> <output-file> [OPTIONS...]
+//
+// With --json, list and show print the devices, or the option descriptors
+// and current values, as JSON for use by scripts.
+//
+// The diff subcommand compares the options of two devices, or the settings
+// of a device with a profile saved as a JSON object of option values in a
+// file whose name ends in .json, and exits with status 1 if they differ:
+//
+//	gosane diff test:0 test:1
+//	gosane diff --json test:0 office.json
+//
+// The tune subcommand, a shorthand for -d DEVICE -i, browses the options of a
+// device by group in the terminal. Options are set with sliders, lists and
+// toggles, options affected by a change are highlighted, and previews are
+// shown as text or, with --sixel, as graphics. The settings can be saved as
+// a profile, which --profile applies to later scans:
+//
+//	gosane tune test --profile office.json
+//	gosane -d test --profile office.json -o page.png
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/tjgq/sane"
+	"io"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+)
+
+var progName = path.Base(os.Args[0])
+
+func die(v ...interface{}) {
+	if len(v) > 0 {
+		fmt.Fprintln(os.Stderr, append([]interface{}{progName + ":"}, v...)...)
+	}
+	os.Exit(1)
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [OPTION]...\n", progName)
+	fmt.Fprintf(w, "       %s list [--json]\n", progName)
+	fmt.Fprintf(w, "       %s show <device-name> [--json] [OPTION]...\n", progName)
+	fmt.Fprintf(w, "       %s scan <device-name> <output-file> [OPTION]...\n", progName)
+	fmt.Fprintf(w, "       %s tune <device-name> [OPTION]...\n", progName)
+	fmt.Fprintf(w, "       %s diff [--json] <device-name> <device-name|profile.json>\n", progName)
+	fmt.Fprint(w, "\nStart image acquisition on a scanner device and write image data to\n")
+	fmt.Fprint(w, "standard output or a file.\n\n")
+	printFlags(w)
+}
+
+func openDevice(name string) (*sane.Conn, error) {
+	if name == "" {
+		// Use the default device, as scanimage does.
+		if name = os.Getenv("SANE_DEFAULT_DEVICE"); name == "" {
+			devs, err := sane.Devices()
+			if err != nil {
+				return nil, err
+			}
+			if len(devs) == 0 {
+				return nil, fmt.Errorf("no SANE devices found")
+			}
+			name = devs[0].Name
+		}
+	}
+	c, err := sane.Open(name)
+	if err == nil {
+		return c, nil
+	}
+	// Try a substring match over the available devices
+	devs, err := sane.Devices()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devs {
+		if strings.Contains(d.Name, name) {
+			return sane.Open(d.Name)
+		}
+	}
+	return nil, fmt.Errorf("no device named %s", name)
+}
+
+// listDevices prints the available devices in the format of scanimage -L.
+func listDevices(w io.Writer) error {
+	devs, err := sane.Devices()
+	if err != nil {
+		return err
+	}
+	if len(devs) == 0 {
+		fmt.Fprint(w, "\nNo scanners were identified. If you were expecting something different,\n"+
+			"check that the scanner is plugged in, turned on and detected by the\n"+
+			"sane-find-scanner tool (if appropriate).\n")
+	}
+	for _, d := range devs {
+		fmt.Fprintf(w, "device `%s' is a %s %s %s\n", d.Name, d.Vendor, d.Model, d.Type)
+	}
+	return nil
+}
+
+// run runs the program with the given command-line options.
+func run(args []string) error {
+	cfg, err := parseArgs(args)
+	if err != nil {
+		return fmt.Errorf("%v; try %s --help", err, progName)
+	}
+	if cfg.list {
+		if cfg.json {
+			return listDevicesJSON(os.Stdout)
+		}
+		return listDevices(os.Stdout)
+	}
+	if cfg.help {
+		usage(os.Stdout)
+		if cfg.device == "" {
+			return nil
+		}
+	}
+	format, err := outputFormat(cfg)
+	if err != nil {
+		return err
+	}
+
+	c, err := openDevice(cfg.device)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if cfg.profile != "" {
+		p, err := readProfile(cfg.profile)
+		switch {
+		case err == nil:
+			if err := c.ApplyProfile(p); err != nil {
+				return fmt.Errorf("profile %s: %v", cfg.profile, err)
+			}
+		case !os.IsNotExist(err) || !cfg.interactive:
+			// In interactive mode, the profile may not have been saved yet.
+			return err
+		}
+	}
+	if err := setOptions(c, cfg.opts); err != nil {
+		return err
+	}
+	if cfg.all && cfg.json && !cfg.help {
+		return printOptionsJSON(os.Stdout, c)
+	}
+	if cfg.help || cfg.all {
+		if cfg.help {
+			fmt.Printf("\nOptions specific to device `%s':\n", c.Device)
+		} else {
+			fmt.Printf("\nAll options specific to device `%s':\n", c.Device)
+		}
+		printOptions(os.Stdout, c)
+		return nil
+	}
+	if cfg.interactive {
+		return runTUI(c, cfg)
+	}
+	if cfg.dontScan {
+		return nil
+	}
+
+	// Cancel the scan on an interrupt.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+			c.Cancel()
+		case <-done:
+		}
+	}()
+	s := &scanner{c: c, cfg: cfg, format: format, stderr: os.Stderr}
+	return s.run(ctx)
+}
+
+func main() {
+	if err := sane.Init(); err != nil {
+		die(err)
+	}
+	defer sane.Exit()
+
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage(os.Stderr)
+		os.Exit(1)
+	}
+	switch args[0] {
+	case "list":
+		args = append([]string{"-L"}, args[1:]...)
+	case "show":
+		if len(args) < 2 {
+			usage(os.Stderr)
+			os.Exit(1)
+		}
+		args = append([]string{"-d", args[1], "-A"}, args[2:]...)
+	case "tune":
+		if len(args) < 2 {
+			usage(os.Stderr)
+			os.Exit(1)
+		}
+		args = append([]string{"-d", args[1], "-i"}, args[2:]...)
+	case "diff":
+		err := runDiff(args[1:])
+		if err == errDiffer {
+			sane.Exit()
+			os.Exit(1)
+		}
+		if err != nil {
+			sane.Exit()
+			die(err)
+		}
+		return
+	case "scan":
+		if len(args) < 3 {
+			usage(os.Stderr)
+			os.Exit(1)
+		}
+		args = append([]string{"-d", args[1], "-o", args[2]}, args[3:]...)
+	}
+	if err := run(args); err != nil {
+		sane.Exit()
+		die(err)
+	}
+}
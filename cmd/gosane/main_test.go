@@ -0,0 +1,407 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/tjgq/sane"
+	"image"
+	"image/color"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArgs(t *testing.T) {
+	cfg, err := parseArgs([]string{
+		"-d", "test", "--mode", "Color", "--resolution=300", "-x", "-5",
+		"--preview", "-p", "--batch=p%d.png", "--batch-start", "3", "-mode", "Gray",
+	})
+	if err != nil {
+		t.Fatal("parse failed:", err)
+	}
+	if cfg.device != "test" || !cfg.progress || !cfg.batch || cfg.pattern != "p%d.png" ||
+		cfg.batchStart != 3 || cfg.batchCount != -1 || cfg.batchIncrement != 1 {
+		t.Errorf("bad config %+v", cfg)
+	}
+	opts := []optArg{
+		{"mode", "Color", true},
+		{"resolution", "300", true},
+		{"x", "-5", true},
+		{"preview", "", false},
+		{"mode", "Gray", true},
+	}
+	if !reflect.DeepEqual(cfg.opts, opts) {
+		t.Errorf("device options are %v, expected %v", cfg.opts, opts)
+	}
+
+	for _, args := range [][]string{
+		{"test"},
+		{"-d"},
+		{"--batch-count", "x"},
+		{"--format", "gif"},
+		{"-L=1"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("parse of %q succeeded", args)
+		}
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	cases := []struct {
+		cfg    config
+		format string
+	}{
+		{config{}, "pnm"},
+		{config{output: "a.PNG"}, "png"},
+		{config{output: "a.tiff"}, "tiff"},
+		{config{output: "a.png", format: "jpeg"}, "jpeg"},
+		{config{output: "a.png", batch: true}, "pnm"},
+		{config{batch: true, pattern: "doc.pdf"}, "pdf"},
+	}
+	for _, c := range cases {
+		if f, err := outputFormat(&c.cfg); err != nil || f != c.format {
+			t.Errorf("format for %+v is %s (%v), expected %s", c.cfg, f, err, c.format)
+		}
+	}
+	if _, err := outputFormat(&config{output: "a.gif"}); err == nil {
+		t.Error("format of a.gif inferred")
+	}
+
+	for _, c := range []struct {
+		pattern, name string
+	}{
+		{"out%d.pnm", "out7.pnm"},
+		{"page%04d.png", "page0007.png"},
+		{"100%-%03d.jpg", "100%-007.jpg"},
+		{"doc.pdf", ""},
+	} {
+		if f, err := outputFormat(&config{batch: true, pattern: c.pattern}); err != nil {
+			t.Errorf("no format for %s: %v", c.pattern, err)
+		} else if !formats[f].multipage && c.name == "" {
+			t.Errorf("format %s of %s cannot hold several pages", f, c.pattern)
+		}
+		name, single := pageNamer(c.pattern)
+		switch {
+		case single != (c.name == ""):
+			t.Errorf("pattern %s is single-file: %v", c.pattern, single)
+		case !single && name(7) != c.name:
+			t.Errorf("page 7 of %s is named %s, expected %s", c.pattern, name(7), c.name)
+		}
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	mm := &sane.Option{Name: "br-x", Type: sane.TypeFloat, Unit: sane.UnitMm, Length: 1}
+	dpi := &sane.Option{Name: "resolution", Type: sane.TypeInt, Unit: sane.UnitDpi, Length: 1, IsAutomatic: true}
+	mode := &sane.Option{Name: "mode", Type: sane.TypeString, ConstrSet: []interface{}{"Gray", "Color"}}
+	flag := &sane.Option{Name: "preview", Type: sane.TypeBool, Length: 1}
+	table := &sane.Option{Name: "gamma-table", Type: sane.TypeInt, Length: 5}
+	cases := []struct {
+		o     *sane.Option
+		s     string
+		value interface{}
+	}{
+		{mm, "210", 210.0},
+		{mm, "210mm", 210.0},
+		{mm, "2cm", 20.0},
+		{mm, "8.5in", 215.9},
+		{mm, "-1.5e1mm", -15.0},
+		{dpi, "300dpi", 300},
+		{dpi, "299.6", 300},
+		{dpi, "auto", sane.Auto},
+		{mode, "color", "Color"},
+		{flag, "yes", true},
+		{flag, "off", false},
+		{table, "7", []int{7, 7, 7, 7, 7}},
+		{table, "1,2,3,4,5", []int{1, 2, 3, 4, 5}},
+		{table, "[0]0-[4]8", []int{0, 2, 4, 6, 8}},
+		{table, "[0]1,[2]5-[4]9", []int{1, 1, 5, 7, 9}},
+	}
+	for _, c := range cases {
+		v, err := parseValue(c.o, c.s)
+		if err != nil {
+			t.Errorf("parse %q for %s failed: %v", c.s, c.o.Name, err)
+			continue
+		}
+		if f, ok := v.(float64); ok {
+			v = math.Round(f*1e6) / 1e6
+		}
+		if !reflect.DeepEqual(v, c.value) {
+			t.Errorf("parse %q for %s gave %#v, expected %#v", c.s, c.o.Name, v, c.value)
+		}
+	}
+	for _, c := range []struct {
+		o *sane.Option
+		s string
+	}{
+		{mm, "210dpi"},
+		{mm, "abc"},
+		{dpi, "300mm"},
+		{mode, "Lineart"},
+		{flag, "maybe"},
+		{table, "1,2"},
+		{table, "[5]1"},
+		{table, "[1]2"},
+	} {
+		if _, err := parseValue(c.o, c.s); err == nil {
+			t.Errorf("parse %q for %s succeeded", c.s, c.o.Name)
+		}
+	}
+}
+
+func TestConstraints(t *testing.T) {
+	cases := []struct {
+		o sane.Option
+		s string
+	}{
+		{sane.Option{Type: sane.TypeBool}, "[=(yes|no)]"},
+		{sane.Option{Type: sane.TypeFloat, Unit: sane.UnitMm, ConstrRange: &sane.Range{Min: 0.0, Max: 215.9, Quant: 0.0}}, "0..215.9mm"},
+		{sane.Option{Type: sane.TypeInt, Unit: sane.UnitDpi, IsAutomatic: true, ConstrSet: []interface{}{75, 150}}, "auto|75|150dpi"},
+		{sane.Option{Type: sane.TypeInt, ConstrRange: &sane.Range{Min: 0, Max: 255, Quant: 1}, Length: 256}, "0..255 (in steps of 1),..."},
+		{sane.Option{Type: sane.TypeString}, "<string>"},
+	}
+	for _, c := range cases {
+		if s := constraints(&c.o); s != c.s {
+			t.Errorf("constraints are %q, expected %q", s, c.s)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	s := wrap("the quick brown fox\njumps", 2, 12)
+	if s != "  the quick\n  brown fox\n  jumps\n" {
+		t.Errorf("bad wrapping: %q", s)
+	}
+	var b bytes.Buffer
+	printFlags(&b)
+	if !strings.Contains(b.String(), "-d, --device-name=DEVICE") {
+		t.Errorf("bad help text:\n%s", b.String())
+	}
+}
+
+func TestOptions(t *testing.T) {
+	if err := sane.Init(); err != nil {
+		t.Fatal("init failed:", err)
+	}
+	defer sane.Exit()
+	c, err := sane.Open("test")
+	if err != nil {
+		t.Fatal("open failed:", err)
+	}
+	defer c.Close()
+
+	err = setOptions(c, []optArg{
+		{"mode", "color", true},
+		{"l", "10mm", true},
+		{"x", "1in", true},
+	})
+	if err != nil {
+		t.Fatal("set options failed:", err)
+	}
+	if v, _ := c.GetOption("mode"); v != "Color" {
+		t.Errorf("mode is %v", v)
+	}
+	if v, _ := c.GetOption("br-x"); v.(float64) < 35.39 || v.(float64) > 35.41 {
+		t.Errorf("br-x is %v, expected 35.4", v)
+	}
+	for _, args := range [][]optArg{
+		{{"nope", "1", true}},
+		{{"mode", "", false}},
+		{{"resolution", "100000", true}},
+	} {
+		if err := setOptions(c, args); err == nil {
+			t.Errorf("setting %v succeeded", args)
+		}
+	}
+
+	var b bytes.Buffer
+	printOptions(&b, c)
+	for _, s := range []string{"    --mode Gray|Color", "[Color]", "    -x 0..200mm [25.4]"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("options lack %q:\n%s", s, b.String())
+		}
+	}
+	b.Reset()
+	if err := printOptionsJSON(&b, c); err != nil {
+		t.Fatal("print options failed:", err)
+	}
+	var j struct {
+		Options []map[string]interface{}
+	}
+	if err := json.Unmarshal(b.Bytes(), &j); err != nil {
+		t.Fatal("bad JSON:", err)
+	}
+	for _, o := range j.Options {
+		if o["name"] == "mode" && o["value"] != "Color" {
+			t.Errorf("mode is %v, expected Color", o["value"])
+		}
+	}
+}
+
+func TestWithValue(t *testing.T) {
+	o := sane.Option{Name: "resolution", Type: sane.TypeInt, Unit: sane.UnitDpi, IsActive: true}
+	b, err := withValue(o, 300)
+	if err != nil {
+		t.Fatal("encoding failed:", err)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatalf("bad JSON %s: %v", b, err)
+	}
+	if m["name"] != "resolution" || m["unit"] != "dpi" || m["value"] != 300.0 {
+		t.Errorf("bad encoding %s", b)
+	}
+	if b, _ := withValue(o, sane.Inactive); !strings.HasSuffix(string(b), `"value":null}`) {
+		t.Errorf("bad encoding of inactive option %s", b)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	mode := sane.Option{Name: "mode", Type: sane.TypeString, ConstrSet: []interface{}{"Gray", "Color"}, IsActive: true}
+	res := sane.Option{Name: "resolution", Type: sane.TypeInt, Unit: sane.UnitDpi, IsActive: true}
+	res2 := res
+	res2.ConstrRange = &sane.Range{Min: 50, Max: 600, Quant: 1}
+	lamp := sane.Option{Name: "lamp", Type: sane.TypeBool}
+
+	a := &side{name: "a", opts: []sane.Option{mode, res, lamp},
+		vals: map[string]interface{}{"mode": "Gray", "resolution": 300}}
+	b := &side{name: "b", opts: []sane.Option{mode, res2},
+		vals: map[string]interface{}{"mode": "Color", "resolution": 300}}
+	var kinds []string
+	for _, d := range compare(a, b) {
+		kinds = append(kinds, d.Name+" "+d.Kind)
+	}
+	expected := []string{"resolution descriptor", "lamp missing", "mode value"}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("differences are %v, expected %v", kinds, expected)
+	}
+
+	// Values decoded from a profile are compared by value.
+	p := &side{name: "p", vals: map[string]interface{}{"resolution": 300.0, "mode": "Color", "nope": 1.0}}
+	kinds = nil
+	for _, d := range compare(a, p) {
+		kinds = append(kinds, d.Name+" "+d.Kind)
+	}
+	expected = []string{"nope missing", "mode value"}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("differences are %v, expected %v", kinds, expected)
+	}
+	for _, c := range []struct {
+		a, b interface{}
+		same bool
+	}{
+		{1234567, 1234567.0, true},
+		{1.0000001, 1.0000002, false},
+		{[]int{1, 2}, []interface{}{1.0, 2.0}, true},
+		{[]int{1, 2}, []interface{}{1.0}, false},
+		{"Gray", "Gray", true},
+		{true, 1.0, false},
+	} {
+		if sameValue(c.a, c.b) != c.same {
+			t.Errorf("sameValue(%v, %v) is %v", c.a, c.b, !c.same)
+		}
+	}
+
+	var buf bytes.Buffer
+	printDiff(&buf, a, p, compare(a, p))
+	if s := buf.String(); s != "nope: only in p\nmode: Gray in a, Color in p\n" {
+		t.Errorf("bad diff output %q", s)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("a\x1b[A\x1b[6~\r\x7f\x1bOB"))
+	expected := []key{'a', keyUp, keyPageDown, keyEnter, keyBackspace, keyDown}
+	for _, e := range expected {
+		if k, err := readKey(r); err != nil || k != e {
+			t.Errorf("key is %v (%v), expected %v", k, err, e)
+		}
+	}
+	r = bufio.NewReader(strings.NewReader("\x1b"))
+	if k, _ := readKey(r); k != keyEscape {
+		t.Errorf("key is %v, expected escape", k)
+	}
+}
+
+func TestRender(t *testing.T) {
+	// A white image with a black left half.
+	m := image.NewGray(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 20; x < 40; x++ {
+			m.SetGray(x, y, color.Gray{255})
+		}
+	}
+	lines := asciiArt(m, 10, 10)
+	if len(lines) != 3 || lines[0] != "@@@@@     " {
+		t.Errorf("bad rendering %q", lines)
+	}
+
+	var b bytes.Buffer
+	if err := writeSixel(&b, m, 12, 12); err != nil {
+		t.Fatal("sixel rendering failed:", err)
+	}
+	s := b.String()
+	if !strings.HasPrefix(s, "\x1bPq\"1;1;12;6") || !strings.HasSuffix(s, "-\x1b\\") {
+		t.Errorf("bad sixel header or trailer: %q", s)
+	}
+	// One band, with black then white runs.
+	if !strings.Contains(s, "#0!6~!6?$#215!6?!6~$-") {
+		t.Errorf("bad sixel data: %q", s)
+	}
+}
+
+func TestWidgets(t *testing.T) {
+	res := &sane.Option{Name: "resolution", Type: sane.TypeInt, Unit: sane.UnitDpi, IsActive: true, IsSettable: true,
+		ConstrRange: &sane.Range{Min: 50, Max: 1250, Quant: 0}}
+	if s := widget(res, 650); s != "[==========|---------] 650dpi" {
+		t.Errorf("bad slider %q", s)
+	}
+	if v := step(res, 650, 1, false); v != 662 {
+		t.Errorf("step is %v, expected 662", v)
+	}
+	if v := step(res, 650, 1, true); v != 770 {
+		t.Errorf("big step is %v, expected 770", v)
+	}
+	if v := step(res, 1240, 1, true); v != 1250 {
+		t.Errorf("step is %v, expected 1250", v)
+	}
+
+	mode := &sane.Option{Name: "mode", Type: sane.TypeString, IsActive: true, IsSettable: true,
+		ConstrSet: []interface{}{"Gray", "Color"}}
+	if s := widget(mode, "Gray"); s != "< Gray >" {
+		t.Errorf("bad picker %q", s)
+	}
+	if v := cycle(mode, "Gray", -1); v != "Color" {
+		t.Errorf("cycled to %v, expected Color", v)
+	}
+	// Some backends report empty sets; such options are edited as text.
+	empty := &sane.Option{Name: "source", Type: sane.TypeString, IsActive: true, IsSettable: true,
+		ConstrSet: []interface{}{}}
+	if s := widget(empty, "Flatbed"); s != "Flatbed" {
+		t.Errorf("bad widget %q for an empty set", s)
+	}
+	if v := cycle(empty, "Flatbed", 1); v != "Flatbed" {
+		t.Errorf("cycled an empty set to %v", v)
+	}
+	for _, c := range []struct {
+		o *sane.Option
+		v interface{}
+		s string
+	}{
+		{&sane.Option{Type: sane.TypeBool, IsActive: true, IsSettable: true}, true, "[x]"},
+		{&sane.Option{Type: sane.TypeButton, IsActive: true, IsSettable: true}, nil, "[ Press ]"},
+		{&sane.Option{Type: sane.TypeInt, IsActive: false}, sane.Inactive, "--"},
+		{&sane.Option{Type: sane.TypeFloat, Unit: sane.UnitMm, IsActive: true}, 25.4, "25.4mm (read-only)"},
+	} {
+		if s := widget(c.o, c.v); s != c.s {
+			t.Errorf("widget is %q, expected %q", s, c.s)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"github.com/tjgq/sane"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Unit suffixes used when printing options, as in scanimage.
+var unitSuffix = map[sane.Unit]string{
+	sane.UnitPixel:   "pel",
+	sane.UnitBit:     "bit",
+	sane.UnitMm:      "mm",
+	sane.UnitDpi:     "dpi",
+	sane.UnitPercent: "%",
+	sane.UnitUsec:    "us",
+}
+
+// Unit suffixes accepted in option values, with their factors to the unit of
+// the option.
+var unitFactors = map[sane.Unit]map[string]float64{
+	sane.UnitNone:    {"": 1},
+	sane.UnitPixel:   {"": 1, "px": 1, "pel": 1},
+	sane.UnitBit:     {"": 1, "bit": 1, "bits": 1},
+	sane.UnitMm:      {"": 1, "mm": 1, "cm": 10, "in": 25.4, "\"": 25.4, "pt": 25.4 / 72},
+	sane.UnitDpi:     {"": 1, "dpi": 1},
+	sane.UnitPercent: {"": 1, "%": 1},
+	sane.UnitUsec:    {"": 1, "us": 1, "ms": 1e3, "s": 1e6},
+}
+
+// Short names used by scanimage for the geometry options. The -x and -y
+// options set the width and height of the scan area instead of the bottom
+// right corner.
+var geometryNames = map[string]string{
+	"tl-x": "l",
+	"tl-y": "t",
+	"br-x": "x",
+	"br-y": "y",
+}
+
+// displayName returns the name of an option as given on the command line.
+func displayName(o *sane.Option) string {
+	if n, ok := geometryNames[o.Name]; ok {
+		return "-" + n
+	}
+	if len(o.Name) == 1 {
+		return "-" + o.Name
+	}
+	return "--" + o.Name
+}
+
+// lookupOption returns the option with the given command-line name, and
+// whether it is the width or height alias of a geometry option.
+func lookupOption(c *sane.Conn, name string) (*sane.Option, bool) {
+	origin := false
+	for opt, n := range geometryNames {
+		if n == name {
+			name, origin = opt, n == "x" || n == "y"
+		}
+	}
+	o, ok := c.LookupOption(name)
+	if !ok {
+		return nil, false
+	}
+	return &o, origin
+}
+
+// constraints describes the values allowed for o, as printed by scanimage.
+func constraints(o *sane.Option) string {
+	var parts []string
+	if o.IsAutomatic {
+		parts = append(parts, "auto")
+	}
+	unit := unitSuffix[o.Unit]
+	switch {
+	case o.Type == sane.TypeBool:
+		return "[=(" + strings.Join(append(parts, "yes", "no"), "|") + ")]"
+	case o.ConstrRange != nil:
+		r := formatValue(o.ConstrRange.Min) + ".." + formatValue(o.ConstrRange.Max) + unit
+		if q, _ := toFloat(o.ConstrRange.Quant); q != 0 {
+			r += " (in steps of " + formatValue(o.ConstrRange.Quant) + ")"
+		}
+		parts = append(parts, r)
+	case o.ConstrSet != nil:
+		var vs []string
+		for _, v := range o.ConstrSet {
+			vs = append(vs, formatValue(v))
+		}
+		parts = append(parts, strings.Join(vs, "|")+unit)
+	case o.Type == sane.TypeString:
+		parts = append(parts, "<string>")
+	case o.Type == sane.TypeInt:
+		parts = append(parts, "<int>"+unit)
+	case o.Type == sane.TypeFloat:
+		parts = append(parts, "<float>"+unit)
+	}
+	if o.Length > 1 && o.Type != sane.TypeString {
+		parts[len(parts)-1] += ",..."
+	}
+	return strings.Join(parts, "|")
+}
+
+// formatValue formats an option value as printed by scanimage, with six
+// significant digits for fixed-point values.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case bool:
+		if v {
+			return "yes"
+		}
+		return "no"
+	case float64:
+		return strconv.FormatFloat(v, 'g', 6, 64)
+	case []bool, []int, []float64, []interface{}:
+		rv := reflect.ValueOf(v)
+		s := make([]string, rv.Len())
+		for i := range s {
+			s[i] = formatValue(rv.Index(i).Interface())
+		}
+		return strings.Join(s, ",")
+	}
+	return fmt.Sprint(v)
+}
+
+// printOptions prints the options of c in the format of scanimage -A.
+func printOptions(w io.Writer, c *sane.Conn) {
+	vals := c.Values()
+	group := ""
+	for i, o := range c.Options() {
+		if o.Group != group || i == 0 {
+			fmt.Fprintf(w, "  %s:\n", o.Group)
+			group = o.Group
+		}
+		fmt.Fprintf(w, "    %s", displayName(&o))
+		if o.Type != sane.TypeButton {
+			fmt.Fprintf(w, " %s", constraints(&o))
+		}
+		v, ok := vals[o.Name]
+		switch {
+		case !o.IsActive:
+			fmt.Fprint(w, " [inactive]")
+		case ok && v != sane.Inactive:
+			if _, origin := lookupOption(c, geometryNames[o.Name]); origin {
+				// Show the width or height of the scan area.
+				tl, _ := toFloat(vals["tl-"+o.Name[3:]])
+				f, _ := toFloat(v)
+				v = f - tl
+			}
+			fmt.Fprintf(w, " [%s]", formatValue(v))
+		}
+		if o.IsActive && !o.IsSettable && o.Type != sane.TypeButton {
+			fmt.Fprint(w, " [read-only]")
+		}
+		if o.IsAdvanced {
+			fmt.Fprint(w, " [advanced]")
+		}
+		fmt.Fprint(w, "\n")
+		fmt.Fprint(w, wrap(o.Desc, 8, 78))
+	}
+}
+
+// wrap wraps text at the given width, indenting every line. Words longer
+// than a line are not broken.
+func wrap(text string, indent, width int) string {
+	var b strings.Builder
+	prefix := strings.Repeat(" ", indent)
+	for _, line := range strings.Split(text, "\n") {
+		pos := 0
+		for _, word := range strings.Fields(line) {
+			if pos > 0 && indent+pos+1+len(word) > width {
+				b.WriteString("\n")
+				pos = 0
+			}
+			if pos == 0 {
+				b.WriteString(prefix + word)
+				pos = len(word)
+			} else {
+				b.WriteString(" " + word)
+				pos += len(word) + 1
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// parseBool parses a boolean option value.
+func parseBool(s string) (bool, error) {
+	switch strings.ToLower(s) {
+	case "yes", "true", "on", "1":
+		return true, nil
+	case "no", "false", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not yes or no", s)
+}
+
+// parseNumber parses a number with an optional unit suffix, such as 210mm or
+// 8.5in, converting it to unit u.
+func parseNumber(s string, u sane.Unit) (float64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= '0' && r <= '9' || r == '.' || r == '-' || r == '+' || r == 'e' || r == 'E')
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	// Do not mistake a leading e for an exponent.
+	for i > 0 && (s[i-1] == 'e' || s[i-1] == 'E') {
+		i--
+	}
+	f, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	suffix := strings.ToLower(strings.TrimSpace(s[i:]))
+	factor, ok := unitFactors[u][suffix]
+	if !ok {
+		if u == sane.UnitNone {
+			return 0, fmt.Errorf("%q: option takes no unit", s)
+		}
+		return 0, fmt.Errorf("%q: unit %s is not valid for %s", s, suffix, u)
+	}
+	return f * factor, nil
+}
+
+// parseScalar parses a single value for o.
+func parseScalar(o *sane.Option, s string) (interface{}, error) {
+	switch o.Type {
+	case sane.TypeBool:
+		return parseBool(s)
+	case sane.TypeInt:
+		f, err := parseNumber(s, o.Unit)
+		if err != nil {
+			return nil, err
+		}
+		return int(math.Floor(f + 0.5)), nil
+	case sane.TypeFloat:
+		return parseNumber(s, o.Unit)
+	}
+	return s, nil
+}
+
+// parseVector parses a value for an option holding several values. The
+// value may be a single value for all entries, a comma-separated list of
+// values for each entry, or use the syntax of scanimage, where [i]v sets
+// entry i to v, a dash between two entries interpolates linearly between
+// them, and entries with no value repeat the previous one.
+func parseVector(o *sane.Option, s string) ([]interface{}, error) {
+	n := o.Length
+	vs := make([]interface{}, n)
+	if !strings.Contains(s, "[") {
+		parts := strings.Split(s, ",")
+		if len(parts) != 1 && len(parts) != n {
+			return nil, fmt.Errorf("expected %d values, got %d", n, len(parts))
+		}
+		for i := range vs {
+			v, err := parseScalar(o, strings.TrimSpace(parts[i%len(parts)]))
+			if err != nil {
+				return nil, err
+			}
+			vs[i] = v
+		}
+		return vs, nil
+	}
+
+	set := make([]bool, n)
+	prev, interp := -1, false
+	for s != "" {
+		idx := prev + 1
+		if s[0] == '[' {
+			j := strings.Index(s, "]")
+			if j < 0 {
+				return nil, fmt.Errorf("missing ] in vector")
+			}
+			var err error
+			if idx, err = strconv.Atoi(s[1:j]); err != nil {
+				return nil, fmt.Errorf("bad vector index %q", s[1:j])
+			}
+			s = s[j+1:]
+		}
+		if idx < 0 || idx >= n {
+			return nil, fmt.Errorf("vector index %d out of range 0..%d", idx, n-1)
+		}
+		j := strings.IndexAny(s, ",-")
+		if j == 0 && s[0] == '-' {
+			j = strings.IndexAny(s[1:], ",-") // negative value
+			if j >= 0 {
+				j++
+			}
+		}
+		if j < 0 {
+			j = len(s)
+		}
+		v, err := parseScalar(o, s[:j])
+		if err != nil {
+			return nil, err
+		}
+		vs[idx], set[idx] = v, true
+		if interp && prev >= 0 && idx > prev+1 {
+			a, _ := toFloat(vs[prev])
+			b, _ := toFloat(v)
+			for k := prev + 1; k < idx; k++ {
+				f := a + (b-a)*float64(k-prev)/float64(idx-prev)
+				if o.Type == sane.TypeInt {
+					vs[k] = int(math.Floor(f + 0.5))
+				} else {
+					vs[k] = f
+				}
+				set[k] = true
+			}
+		}
+		prev, interp = idx, j < len(s) && s[j] == '-'
+		if j < len(s) {
+			j++
+		}
+		s = s[j:]
+	}
+	for i := range vs {
+		if set[i] {
+			continue
+		}
+		if i == 0 {
+			return nil, fmt.Errorf("vector entry 0 not given")
+		}
+		vs[i] = vs[i-1]
+	}
+	return vs, nil
+}
+
+// parseValue parses a command-line value for o, converting it to the type
+// expected by sane.Conn.SetOption.
+func parseValue(o *sane.Option, s string) (interface{}, error) {
+	if o.IsAutomatic && strings.ToLower(s) == "auto" {
+		return sane.Auto, nil
+	}
+	if o.Type == sane.TypeString {
+		if o.ConstrSet == nil {
+			return s, nil
+		}
+		// Accept any unambiguous case-insensitive match, as scanimage does.
+		var match interface{}
+		for _, v := range o.ConstrSet {
+			switch {
+			case v == s:
+				return s, nil
+			case strings.EqualFold(v.(string), s):
+				match = v
+			}
+		}
+		if match == nil {
+			return nil, fmt.Errorf("%q is not one of %s", s, constraints(o))
+		}
+		return match, nil
+	}
+	if o.Length <= 1 {
+		return parseScalar(o, s)
+	}
+	vs, err := parseVector(o, s)
+	if err != nil {
+		return nil, err
+	}
+	switch o.Type {
+	case sane.TypeBool:
+		r := make([]bool, len(vs))
+		for i, v := range vs {
+			r[i] = v.(bool)
+		}
+		return r, nil
+	case sane.TypeInt:
+		r := make([]int, len(vs))
+		for i, v := range vs {
+			r[i] = v.(int)
+		}
+		return r, nil
+	default:
+		r := make([]float64, len(vs))
+		for i, v := range vs {
+			r[i] = v.(float64)
+		}
+		return r, nil
+	}
+}
+
+// setOptions sets the device options given on the command line, in order.
+func setOptions(c *sane.Conn, args []optArg) error {
+	for _, a := range args {
+		o, origin := lookupOption(c, a.name)
+		if o == nil {
+			return fmt.Errorf("unrecognized option -%s; use --help to list the device options", a.name)
+		}
+		name := displayName(o)
+		if !a.hasValue {
+			if o.Type != sane.TypeBool && o.Type != sane.TypeButton {
+				return fmt.Errorf("option %s requires a value (%s)", name, constraints(o))
+			}
+			a.value = "yes"
+		}
+		if !o.IsActive {
+			return fmt.Errorf("option %s is inactive", name)
+		}
+		if !o.IsSettable {
+			return fmt.Errorf("option %s is read-only", name)
+		}
+		if o.Type == sane.TypeButton {
+			if _, err := c.SetOption(o.Name, nil); err != nil {
+				return fmt.Errorf("option %s: %v", name, err)
+			}
+			continue
+		}
+		v, err := parseValue(o, a.value)
+		if err != nil {
+			return fmt.Errorf("option %s: %v", name, err)
+		}
+		if origin && v != sane.Auto {
+			// -x and -y are relative to the top left corner.
+			tl, err := c.GetOption("tl-" + o.Name[3:])
+			if err == nil {
+				t, _ := toFloat(tl)
+				f, _ := toFloat(v)
+				v = fromFloat(o, t+f)
+			}
+		}
+		if _, err := c.SetOption(o.Name, v); err != nil {
+			if err == sane.ErrInvalid {
+				return fmt.Errorf("option %s: invalid value %s (allowed: %s)", name, a.value, constraints(o))
+			}
+			return fmt.Errorf("option %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// fromFloat converts f to the type of o.
+func fromFloat(o *sane.Option, f float64) interface{} {
+	if o.Type == sane.TypeInt {
+		return int(math.Floor(f + 0.5))
+	}
+	return f
+}
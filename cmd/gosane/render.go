@@ -0,0 +1,144 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+)
+
+// Characters used to render images as text, from light to dark.
+const asciiRamp = " .:-=+*#%@"
+
+// Assumed size of a character cell, in pixels. Cells are about twice as high
+// as they are wide.
+const (
+	cellWidth  = 8
+	cellHeight = 16
+)
+
+// fit returns the largest size with the aspect ratio of r that fits in
+// width by height units, where a unit is aspect times as high as it is wide.
+func fit(r image.Rectangle, width, height int, aspect float64) (int, int) {
+	if r.Empty() || width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	ratio := float64(r.Dy()) / float64(r.Dx()) / aspect
+	w, h := width, int(math.Round(float64(width)*ratio))
+	if h > height {
+		w, h = int(math.Round(float64(height)/ratio)), height
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// luminance returns the luminance of the pixel at (x, y) of m, from 0 for
+// black to 1 for white.
+func luminance(m image.Image, x, y int) float64 {
+	r, g, b, _ := m.At(x, y).RGBA()
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+}
+
+// asciiArt renders m as at most cols by rows characters, keeping its aspect
+// ratio. Each character stands for the average luminance of the pixels it
+// covers.
+func asciiArt(m image.Image, cols, rows int) []string {
+	b := m.Bounds()
+	w, h := fit(b, cols, rows, cellHeight/cellWidth)
+	lines := make([]string, h)
+	for row := 0; row < h; row++ {
+		y0, y1 := b.Min.Y+row*b.Dy()/h, b.Min.Y+(row+1)*b.Dy()/h
+		line := make([]byte, w)
+		for col := 0; col < w; col++ {
+			x0, x1 := b.Min.X+col*b.Dx()/w, b.Min.X+(col+1)*b.Dx()/w
+			sum, n := 0.0, 0
+			for y := y0; y < y1 || y == y0; y++ {
+				for x := x0; x < x1 || x == x0; x++ {
+					sum += luminance(m, x, y)
+					n++
+				}
+			}
+			i := int((1 - sum/float64(n)) * float64(len(asciiRamp)-1))
+			line[col] = asciiRamp[i]
+		}
+		lines[row] = string(line)
+	}
+	return lines
+}
+
+// writeSixel renders m as sixel graphics of at most width by height pixels,
+// keeping its aspect ratio. Colors are reduced to a 6x6x6 color cube.
+func writeSixel(w io.Writer, m image.Image, width, height int) error {
+	b := m.Bounds()
+	sw, sh := fit(b, width, height, 1)
+	// Scale with the nearest pixel, and map each pixel to a palette index.
+	px := make([][]int, sh)
+	for y := range px {
+		px[y] = make([]int, sw)
+		for x := range px[y] {
+			r, g, bl, _ := m.At(b.Min.X+x*b.Dx()/sw, b.Min.Y+y*b.Dy()/sh).RGBA()
+			px[y][x] = int(r*5/0xffff)*36 + int(g*5/0xffff)*6 + int(bl*5/0xffff)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "\x1bPq\"1;1;%d;%d", sw, sh)
+	for i := 0; i < 216; i++ {
+		fmt.Fprintf(bw, "#%d;2;%d;%d;%d", i, i/36*20, i/6%6*20, i%6*20)
+	}
+	for y0 := 0; y0 < sh; y0 += 6 {
+		var used [216]bool
+		for y := y0; y < y0+6 && y < sh; y++ {
+			for _, c := range px[y] {
+				used[c] = true
+			}
+		}
+		for c := range used {
+			if !used[c] {
+				continue
+			}
+			fmt.Fprintf(bw, "#%d", c)
+			var last byte
+			run := 0
+			flush := func() {
+				if run > 3 {
+					fmt.Fprintf(bw, "!%d%c", run, last)
+				} else {
+					for ; run > 0; run-- {
+						bw.WriteByte(last)
+					}
+				}
+				run = 0
+			}
+			for x := 0; x < sw; x++ {
+				bits := 0
+				for dy := 0; dy < 6 && y0+dy < sh; dy++ {
+					if px[y0+dy][x] == c {
+						bits |= 1 << uint(dy)
+					}
+				}
+				ch := byte(63 + bits)
+				if run > 0 && ch != last {
+					flush()
+				}
+				last = ch
+				run++
+			}
+			flush()
+			bw.WriteByte('$')
+		}
+		bw.WriteByte('-')
+	}
+	bw.WriteString("\x1b\\")
+	return bw.Flush()
+}
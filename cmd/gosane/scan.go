@@ -0,0 +1,263 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/tjgq/sane"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// A format is an output file format.
+type format struct {
+	ext       string                                 // file name extension
+	multipage bool                                   // whether a file can hold several pages
+	encode    func(w io.Writer, m *sane.Image) error // writes a single page
+}
+
+// Output formats by name.
+var formats = map[string]format{
+	"pnm": {".pnm", false, func(w io.Writer, m *sane.Image) error {
+		return m.WritePNM(w)
+	}},
+	"tiff": {".tif", true, sane.EncodeTIFF},
+	"png":  {".png", false, sane.EncodePNG},
+	"jpeg": {".jpg", false, func(w io.Writer, m *sane.Image) error {
+		return sane.EncodeJPEG(w, m, nil)
+	}},
+	"pdf": {".pdf", true, func(w io.Writer, m *sane.Image) error {
+		return sane.EncodePDF(w, []*sane.Image{m})
+	}},
+}
+
+// Formats by file name extension, in addition to the default extensions.
+var extFormats = map[string]string{
+	".pbm":  "pnm",
+	".pgm":  "pnm",
+	".ppm":  "pnm",
+	".pam":  "pnm",
+	".tiff": "tiff",
+	".jpeg": "jpeg",
+}
+
+// formatFor returns the name of the format for a file name, or an empty
+// string if the extension is not recognized.
+func formatFor(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if f, ok := extFormats[ext]; ok {
+		return f
+	}
+	for f, info := range formats {
+		if info.ext == ext {
+			return f
+		}
+	}
+	return ""
+}
+
+// outputFormat returns the output format selected by cfg. It is given by
+// --format, or else inferred from the output file or batch pattern, and is
+// pnm by default.
+func outputFormat(cfg *config) (string, error) {
+	if cfg.format != "" {
+		return cfg.format, nil
+	}
+	name := cfg.output
+	if cfg.batch {
+		name = cfg.pattern
+	}
+	if name == "" {
+		return "pnm", nil
+	}
+	if f := formatFor(name); f != "" {
+		return f, nil
+	}
+	return "", fmt.Errorf("cannot infer the format of %s; use --format", name)
+}
+
+// scanner scans images as configured on the command line.
+type scanner struct {
+	c      *sane.Conn
+	cfg    *config
+	format string
+	stderr io.Writer // destination of messages
+}
+
+// progress prints the progress of the current page.
+func (s *scanner) progress(p sane.Progress) {
+	if f, ok := p.Fraction(); ok {
+		fmt.Fprintf(s.stderr, "Progress: %3.1f%%\r", 100*f)
+	}
+}
+
+// run scans as configured.
+func (s *scanner) run(ctx context.Context) error {
+	if s.cfg.progress {
+		s.c.ProgressFunc = s.progress
+		defer func() { s.c.ProgressFunc = nil }()
+	}
+	if s.cfg.batch {
+		return s.batch(ctx)
+	}
+
+	w := io.Writer(os.Stdout)
+	if s.cfg.output != "" {
+		f, err := create(s.cfg.output)
+		if err != nil {
+			return err
+		}
+		defer f.abort()
+		w = f
+	}
+	var err error
+	if s.format == "pnm" {
+		// Write each line as soon as it is scanned.
+		err = s.c.StreamPNM(w)
+	} else {
+		var m *sane.Image
+		if m, err = s.c.ReadImage(); err == nil {
+			err = formats[s.format].encode(w, m)
+		}
+	}
+	if s.cfg.progress {
+		fmt.Fprintln(s.stderr)
+	}
+	if f, ok := w.(*outFile); ok && err == nil {
+		err = f.commit()
+	}
+	return err
+}
+
+// pageVerb matches the page number verb of a batch pattern, such as %d or
+// %03d.
+var pageVerb = regexp.MustCompile(`%[0-9]*d`)
+
+// pageNamer returns a function naming the file of each page after a batch
+// pattern, in which any % other than the page number verb is literal. If the
+// pattern has no page number verb, it returns nil and true.
+func pageNamer(pattern string) (name func(n int) string, single bool) {
+	loc := pageVerb.FindStringIndex(pattern)
+	if loc == nil {
+		return nil, true
+	}
+	esc := func(s string) string { return strings.Replace(s, "%", "%%", -1) }
+	format := esc(pattern[:loc[0]]) + pattern[loc[0]:loc[1]] + esc(pattern[loc[1]:])
+	return func(n int) string { return fmt.Sprintf(format, n) }, false
+}
+
+// batch scans pages until the document feeder is empty or the page count is
+// reached, writing each page to a file named after the batch pattern. If the
+// pattern does not contain %d and the format allows, all pages are written
+// to the same file.
+func (s *scanner) batch(ctx context.Context) error {
+	f := formats[s.format]
+	pattern := s.cfg.pattern
+	if pattern == "" {
+		pattern = "out%d" + f.ext
+	}
+	name, single := pageNamer(pattern)
+	if single && !f.multipage {
+		return fmt.Errorf("batch pattern %s must contain %%d for format %s", pattern, s.format)
+	}
+	if s.cfg.waitPaper {
+		fmt.Fprintln(s.stderr, "Waiting for paper...")
+		if err := s.c.WaitForPaper(ctx); err != nil {
+			return err
+		}
+	}
+
+	var pages []*sane.Image
+	n := s.cfg.batchStart
+	count := 0
+	for ; s.cfg.batchCount < 0 || count < s.cfg.batchCount; count++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		fmt.Fprintf(s.stderr, "Scanning page %d\n", n)
+		m, err := s.c.ReadImage()
+		if s.cfg.progress {
+			fmt.Fprintln(s.stderr)
+		}
+		if err == sane.ErrEmpty {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(s.stderr, "Scanned page %d.\n", n)
+		if single {
+			pages = append(pages, m)
+		} else if err := writeFile(name(n), m, f.encode); err != nil {
+			return err
+		}
+		n += s.cfg.batchIncrement
+	}
+	fmt.Fprintf(s.stderr, "Batch terminated, %d pages scanned\n", count)
+	if count == 0 {
+		return sane.ErrEmpty
+	}
+	if single {
+		return writeFile(pattern, nil, func(w io.Writer, _ *sane.Image) error {
+			if s.format == "pdf" {
+				return sane.EncodePDF(w, pages)
+			}
+			tw := sane.NewTIFFWriter(w)
+			for _, m := range pages {
+				if err := tw.AddPage(m); err != nil {
+					return err
+				}
+			}
+			return tw.Close()
+		})
+	}
+	return nil
+}
+
+// writeFile writes m to the named file with the given encoder.
+func writeFile(name string, m *sane.Image, encode func(io.Writer, *sane.Image) error) error {
+	f, err := create(name)
+	if err != nil {
+		return err
+	}
+	defer f.abort()
+	if err := encode(f, m); err != nil {
+		return err
+	}
+	return f.commit()
+}
+
+// An outFile is an output file that is removed unless committed, so that
+// failed scans leave no partial files behind.
+type outFile struct {
+	*os.File
+	done bool
+}
+
+func create(name string) (*outFile, error) {
+	f, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &outFile{File: f}, nil
+}
+
+// commit closes the file, keeping it.
+func (f *outFile) commit() error {
+	f.done = true
+	return f.Close()
+}
+
+// abort closes and removes the file, unless it was committed.
+func (f *outFile) abort() {
+	if !f.done {
+		f.Close()
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A key is a key press read from the terminal: a character, or one of the
+// special keys below.
+type key rune
+
+// Special keys, which are negative so as not to collide with characters.
+const (
+	keyUp key = -1 - iota
+	keyDown
+	keyLeft
+	keyRight
+	keyHome
+	keyEnd
+	keyPageUp
+	keyPageDown
+	keyEnter
+	keyEscape
+	keyBackspace
+	keyTab
+	keyInterrupt
+	keyUnknown
+)
+
+// Escape sequences for special keys, without the leading ESC.
+var keySeqs = map[string]key{
+	"[A":  keyUp,
+	"[B":  keyDown,
+	"[C":  keyRight,
+	"[D":  keyLeft,
+	"[H":  keyHome,
+	"[F":  keyEnd,
+	"OA":  keyUp,
+	"OB":  keyDown,
+	"OC":  keyRight,
+	"OD":  keyLeft,
+	"OH":  keyHome,
+	"OF":  keyEnd,
+	"[1~": keyHome,
+	"[4~": keyEnd,
+	"[5~": keyPageUp,
+	"[6~": keyPageDown,
+}
+
+// readKey reads a key press. An escape character not immediately followed
+// by the rest of a sequence is taken as the escape key.
+func readKey(r *bufio.Reader) (key, error) {
+	c, _, err := r.ReadRune()
+	if err != nil {
+		return 0, err
+	}
+	switch c {
+	case '\r', '\n':
+		return keyEnter, nil
+	case '\t':
+		return keyTab, nil
+	case 127, '\b':
+		return keyBackspace, nil
+	case 3:
+		return keyInterrupt, nil
+	case 27:
+	default:
+		return key(c), nil
+	}
+	if r.Buffered() == 0 {
+		return keyEscape, nil
+	}
+	var seq strings.Builder
+	for r.Buffered() > 0 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		seq.WriteByte(b)
+		// Sequences end with a letter or a tilde.
+		if seq.Len() > 1 && (b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b == '~') {
+			break
+		}
+	}
+	if k, ok := keySeqs[seq.String()]; ok {
+		return k, nil
+	}
+	return keyUnknown, nil
+}
+
+// A terminal is the controlling terminal in raw mode, with the alternate
+// screen selected.
+type terminal struct {
+	in     *bufio.Reader
+	out    *bufio.Writer
+	state  string // terminal settings to restore, as saved by stty
+	width  int    // number of columns
+	height int    // number of rows
+}
+
+// stty runs stty on the standard input with the given arguments, returning
+// its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	b, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s: %v", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// openTerminal puts the terminal in raw mode. It fails if the standard input
+// is not a terminal.
+func openTerminal() (*terminal, error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("standard input is not a terminal")
+	}
+	t := &terminal{
+		in:    bufio.NewReader(os.Stdin),
+		out:   bufio.NewWriter(os.Stdout),
+		state: state,
+	}
+	if err := t.resize(); err != nil {
+		return nil, err
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, err
+	}
+	// Select the alternate screen and hide the cursor.
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	return t, t.out.Flush()
+}
+
+// resize reads the size of the terminal.
+func (t *terminal) resize() error {
+	size, err := stty("size")
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Sscan(size, &t.height, &t.width); err != nil {
+		return fmt.Errorf("bad terminal size %q", size)
+	}
+	return nil
+}
+
+// close restores the terminal.
+func (t *terminal) close() error {
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	_, err := stty(t.state)
+	return err
+}
+
+// readKey reads a key press.
+func (t *terminal) readKey() (key, error) {
+	return readKey(t.in)
+}
+
+// clear clears the screen.
+func (t *terminal) clear() {
+	t.out.WriteString("\x1b[H\x1b[2J")
+}
+
+// move moves the cursor to the given zero-based row and column.
+func (t *terminal) move(row, col int) {
+	fmt.Fprintf(t.out, "\x1b[%d;%dH", row+1, col+1)
+}
+
+// Text attributes.
+const (
+	attrNormal  = "\x1b[0m"
+	attrBold    = "\x1b[1m"
+	attrDim     = "\x1b[2m"
+	attrReverse = "\x1b[7m"
+	attrChanged = "\x1b[33m" // yellow, for options changed by a reload
+)
+
+// line writes s at the given row, padded or truncated to the width of the
+// terminal, with the given attributes.
+func (t *terminal) line(row int, attr, s string) {
+	t.move(row, 0)
+	t.out.WriteString(attr + pad(s, t.width) + attrNormal)
+}
+
+// pad pads s with spaces or truncates it to n characters.
+func pad(s string, n int) string {
+	r := []rune(s)
+	if len(r) > n {
+		return string(r[:n])
+	}
+	return s + strings.Repeat(" ", n-len(r))
+}
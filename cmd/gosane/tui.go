@@ -0,0 +1,592 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/tjgq/sane"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Width of the option title column, and of range sliders.
+const (
+	titleWidth  = 28
+	sliderWidth = 20
+)
+
+// Help line of the interactive mode.
+const tuiHelp = "up/down select  left/right adjust  </> big steps  enter edit  a auto  p preview  s save  v advanced  q quit"
+
+// A row is a line of the option list: a group header or an option.
+type row struct {
+	group string       // group title, for a header
+	opt   *sane.Option // option, or nil for a header
+}
+
+// A tui is the interactive mode, where the options of a device can be
+// browsed and set.
+type tui struct {
+	c        *sane.Conn
+	t        *terminal
+	sixel    bool                   // render previews as sixel graphics
+	profile  string                 // file to save the profile to
+	advanced bool                   // show advanced options
+	rows     []row                  // rows of the option list
+	vals     map[string]interface{} // current option values
+	changed  map[string]bool        // options affected by the last change
+	sel      int                    // selected row
+	top      int                    // first row shown
+	msg      string                 // status message
+}
+
+// runTUI runs the interactive mode on c until the user quits.
+func runTUI(c *sane.Conn, cfg *config) error {
+	t, err := openTerminal()
+	if err != nil {
+		return err
+	}
+	defer t.close()
+	u := &tui{c: c, t: t, sixel: cfg.sixel, profile: cfg.profile}
+	if u.profile == "" {
+		u.profile = "profile.json"
+	}
+	c.OptionsDiff() // forget changes made on the command line
+	u.load()
+	u.msg = "Options of " + c.Device
+	for {
+		u.draw()
+		k, err := t.readKey()
+		if err != nil {
+			return err
+		}
+		if done := u.handle(k); done {
+			return nil
+		}
+	}
+}
+
+// load reads the options and their values, keeping the selected option.
+func (u *tui) load() {
+	var selName string
+	if o := u.selected(); o != nil {
+		selName = o.Name
+	}
+	u.vals = u.c.Values()
+	u.rows = u.rows[:0]
+	opts := u.c.Options()
+	group := ""
+	for i := range opts {
+		o := &opts[i]
+		if o.IsAdvanced && !u.advanced {
+			continue
+		}
+		if o.Group != group || len(u.rows) == 0 {
+			group = o.Group
+			u.rows = append(u.rows, row{group: group})
+		}
+		u.rows = append(u.rows, row{opt: o})
+	}
+	u.sel = -1
+	for i, r := range u.rows {
+		if r.opt == nil {
+			continue
+		}
+		if u.sel < 0 {
+			u.sel = i
+		}
+		if r.opt.Name == selName {
+			u.sel = i
+			break
+		}
+	}
+	if u.sel < 0 {
+		u.sel = 0
+	}
+}
+
+// selected returns the selected option, or nil.
+func (u *tui) selected() *sane.Option {
+	if u.sel < 0 || u.sel >= len(u.rows) {
+		return nil
+	}
+	return u.rows[u.sel].opt
+}
+
+// listHeight returns the number of rows of the option list.
+func (u *tui) listHeight() int {
+	if h := u.t.height - 5; h > 1 {
+		return h
+	}
+	return 1
+}
+
+// move selects the option n rows up or down, skipping headers.
+func (u *tui) move(n int) {
+	dir := 1
+	if n < 0 {
+		dir = -1
+	}
+	for i := u.sel; n != 0; n -= dir {
+		for i += dir; i >= 0 && i < len(u.rows) && u.rows[i].opt == nil; i += dir {
+		}
+		if i < 0 || i >= len(u.rows) {
+			break
+		}
+		u.sel = i
+	}
+}
+
+// draw redraws the screen.
+func (u *tui) draw() {
+	t := u.t
+	h := u.listHeight()
+	if u.sel < u.top {
+		u.top = u.sel
+		// Show the header of the first group.
+		if u.top == 1 {
+			u.top = 0
+		}
+	}
+	if u.sel >= u.top+h {
+		u.top = u.sel - h + 1
+	}
+
+	t.clear()
+	t.line(0, attrReverse, " gosane: "+u.c.Device)
+	for i := 0; i < h; i++ {
+		n := u.top + i
+		if n >= len(u.rows) {
+			break
+		}
+		r := u.rows[n]
+		if r.opt == nil {
+			t.line(1+i, attrBold, r.group)
+			continue
+		}
+		o := r.opt
+		attr := attrNormal
+		switch {
+		case n == u.sel:
+			attr = attrReverse
+		case u.changed[o.Name]:
+			attr = attrChanged
+		case !o.IsActive:
+			attr = attrDim
+		}
+		title := o.Title
+		if title == "" {
+			title = o.Name
+		}
+		t.line(1+i, attr, "  "+pad(title, titleWidth)+" "+widget(o, u.vals[o.Name]))
+	}
+
+	// Describe the selected option below the list.
+	desc := ""
+	if o := u.selected(); o != nil {
+		desc = displayName(o)
+		if o.Type != sane.TypeButton {
+			desc += " " + constraints(o)
+		}
+		desc += ": " + strings.Join(strings.Fields(o.Desc), " ")
+	}
+	lines := strings.Split(wrap(desc, 0, t.width), "\n")
+	for i := 0; i < 2; i++ {
+		s := ""
+		if i < len(lines) {
+			s = lines[i]
+		}
+		t.line(t.height-4+i, attrDim, s)
+	}
+	t.line(t.height-2, attrNormal, u.msg)
+	t.line(t.height-1, attrReverse, tuiHelp)
+	t.out.Flush()
+}
+
+// widget renders the value of o, in a way that suggests how it is edited.
+func widget(o *sane.Option, v interface{}) string {
+	unit := unitSuffix[o.Unit]
+	var s string
+	switch {
+	case o.Type == sane.TypeButton:
+		s = "[ Press ]"
+	case !o.IsActive || v == nil || v == sane.Inactive:
+		s = "--"
+	case o.Type == sane.TypeBool && o.Length <= 1:
+		s = "[ ]"
+		if v == true {
+			s = "[x]"
+		}
+	case o.ConstrRange != nil && o.Length <= 1:
+		s = slider(o.ConstrRange, v) + " " + formatValue(v) + unit
+	case len(o.ConstrSet) > 0 && o.Length <= 1:
+		s = "< " + formatValue(v) + unit + " >"
+	case o.Length > 1:
+		s = fmt.Sprintf("(%d values)", o.Length)
+	default:
+		s = formatValue(v) + unit
+	}
+	if o.IsActive && !o.IsSettable && o.Type != sane.TypeButton {
+		s += " (read-only)"
+	}
+	return s
+}
+
+// slider renders the position of v in range r.
+func slider(r *sane.Range, v interface{}) string {
+	min, _ := toFloat(r.Min)
+	max, _ := toFloat(r.Max)
+	f, _ := toFloat(v)
+	pos := 0
+	if max > min {
+		pos = int(math.Round((f - min) / (max - min) * (sliderWidth - 1)))
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	if pos > sliderWidth-1 {
+		pos = sliderWidth - 1
+	}
+	return "[" + strings.Repeat("=", pos) + "|" + strings.Repeat("-", sliderWidth-1-pos) + "]"
+}
+
+// stepSize returns the step of a range option, which is the quantization of
+// the range or, if there is none, a hundredth of it.
+func stepSize(o *sane.Option) float64 {
+	min, _ := toFloat(o.ConstrRange.Min)
+	max, _ := toFloat(o.ConstrRange.Max)
+	if q, _ := toFloat(o.ConstrRange.Quant); q > 0 {
+		return q
+	}
+	q := (max - min) / 100
+	if o.Type == sane.TypeInt && q < 1 {
+		q = 1
+	}
+	return q
+}
+
+// step returns the value of a range option v moved by n steps, clamped to
+// the range. If big is true, a step is a tenth of the range instead.
+func step(o *sane.Option, v interface{}, n int, big bool) interface{} {
+	min, _ := toFloat(o.ConstrRange.Min)
+	max, _ := toFloat(o.ConstrRange.Max)
+	q := stepSize(o)
+	if big {
+		q *= math.Max(1, math.Round((max-min)/10/q))
+	}
+	f, _ := toFloat(v)
+	f = math.Max(min, math.Min(max, f+float64(n)*q))
+	if q, _ := toFloat(o.ConstrRange.Quant); q > 0 {
+		// Stay on a step of the range.
+		f = math.Min(max, min+math.Round((f-min)/q)*q)
+	}
+	return fromFloat(o, f)
+}
+
+// cycle returns the entry of the set of o n places from v, wrapping around.
+// It returns v if the set is empty.
+func cycle(o *sane.Option, v interface{}, n int) interface{} {
+	set := o.ConstrSet
+	if len(set) == 0 {
+		return v
+	}
+	i := 0
+	for j, x := range set {
+		if formatValue(x) == formatValue(v) {
+			i = j
+		}
+	}
+	return set[((i+n)%len(set)+len(set))%len(set)]
+}
+
+// handle acts on a key press, returning true if the user quit.
+func (u *tui) handle(k key) bool {
+	u.msg = ""
+	o := u.selected()
+	editable := o != nil && o.IsActive && o.IsSettable
+	switch k {
+	case 'q', keyEscape, keyInterrupt:
+		return true
+	case keyUp, 'k':
+		u.move(-1)
+	case keyDown, 'j':
+		u.move(1)
+	case keyPageUp:
+		u.move(-u.listHeight())
+	case keyPageDown:
+		u.move(u.listHeight())
+	case keyHome:
+		u.sel = 0
+		u.move(1)
+	case keyEnd:
+		u.sel = len(u.rows) - 1
+	case 12: // Ctrl-L
+		if err := u.t.resize(); err != nil {
+			u.msg = err.Error()
+		}
+	case 'v':
+		u.advanced = !u.advanced
+		u.load()
+		if u.advanced {
+			u.msg = "Showing advanced options"
+		} else {
+			u.msg = "Hiding advanced options"
+		}
+	case 'p':
+		u.preview()
+	case 's':
+		u.save()
+	case 'a':
+		if editable && o.IsAutomatic {
+			u.set(o, sane.Auto)
+		} else if o != nil {
+			u.msg = displayName(o) + " has no automatic value"
+		}
+	case keyLeft, keyRight, '<', '>', 'h', 'l':
+		if !editable || o.Length > 1 {
+			break
+		}
+		n := 1
+		if k == keyLeft || k == '<' || k == 'h' {
+			n = -1
+		}
+		v := u.vals[o.Name]
+		switch {
+		case o.Type == sane.TypeBool:
+			u.set(o, v != true)
+		case len(o.ConstrSet) > 0:
+			u.set(o, cycle(o, v, n))
+		case o.ConstrRange != nil:
+			u.set(o, step(o, v, n, k == '<' || k == '>'))
+		}
+	case keyEnter, ' ':
+		switch {
+		case o == nil:
+		case !o.IsActive:
+			u.msg = displayName(o) + " is inactive"
+		case !o.IsSettable:
+			u.msg = displayName(o) + " is read-only"
+		case o.Type == sane.TypeButton:
+			u.set(o, nil)
+		case o.Type == sane.TypeBool && o.Length <= 1:
+			u.set(o, u.vals[o.Name] != true)
+		case len(o.ConstrSet) > 0 && o.Length <= 1:
+			if v, ok := u.pick(o); ok {
+				u.set(o, v)
+			}
+		default:
+			u.edit(o)
+		}
+	}
+	return false
+}
+
+// set sets an option and reloads the options, highlighting those affected.
+func (u *tui) set(o *sane.Option, v interface{}) {
+	name := displayName(o)
+	info, err := u.c.SetOption(o.Name, v)
+	if err == sane.ErrInvalid {
+		err = fmt.Errorf("invalid value (allowed: %s)", constraints(o))
+	}
+	if err != nil {
+		u.msg = fmt.Sprintf("%s: %v", name, err)
+		return
+	}
+	u.changed = make(map[string]bool)
+	if info.ReloadOpts {
+		for _, ch := range u.c.OptionsDiff() {
+			if ch.Name != o.Name {
+				u.changed[ch.Name] = true
+			}
+		}
+	}
+	u.load()
+	if o.Type == sane.TypeButton {
+		u.msg = name + " pressed"
+		return
+	}
+	u.msg = fmt.Sprintf("%s set to %s", name, formatValue(u.vals[o.Name]))
+	if info.Inexact {
+		u.msg += " (rounded by the device)"
+	}
+	if n := len(u.changed); n > 0 {
+		names := make([]string, 0, n)
+		for name := range u.changed {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		u.msg += "; also changed: " + strings.Join(names, ", ")
+	}
+}
+
+// edit prompts for a new value of o, in command-line syntax.
+func (u *tui) edit(o *sane.Option) {
+	cur := ""
+	if v, ok := u.vals[o.Name]; ok && v != sane.Inactive {
+		cur = formatValue(v)
+	}
+	s, ok := u.prompt(displayName(o)+" ("+constraints(o)+"): ", cur)
+	if !ok {
+		return
+	}
+	v, err := parseValue(o, s)
+	if err != nil {
+		u.msg = fmt.Sprintf("%s: %v", displayName(o), err)
+		return
+	}
+	u.set(o, v)
+}
+
+// prompt reads a line of text on the status line, starting with init. It
+// returns false if the user cancelled with Escape.
+func (u *tui) prompt(label, init string) (string, bool) {
+	t := u.t
+	s := []rune(init)
+	t.out.WriteString("\x1b[?25h")
+	defer t.out.WriteString("\x1b[?25l")
+	for {
+		t.line(t.height-2, attrNormal, label+string(s))
+		t.move(t.height-2, len([]rune(label))+len(s))
+		t.out.Flush()
+		k, err := t.readKey()
+		if err != nil {
+			return "", false
+		}
+		switch {
+		case k == keyEnter:
+			return string(s), true
+		case k == keyEscape || k == keyInterrupt:
+			return "", false
+		case k == keyBackspace:
+			if len(s) > 0 {
+				s = s[:len(s)-1]
+			}
+		case k == 21: // Ctrl-U
+			s = s[:0]
+		case k >= ' ':
+			s = append(s, rune(k))
+		}
+	}
+}
+
+// pick lets the user choose a value of o from its set in a pop-up list. It
+// returns false if the user cancelled with Escape, or if the set is empty.
+func (u *tui) pick(o *sane.Option) (interface{}, bool) {
+	t := u.t
+	set := o.ConstrSet
+	if len(set) == 0 {
+		return nil, false
+	}
+	sel := 0
+	width := 0
+	for i, v := range set {
+		if formatValue(v) == formatValue(u.vals[o.Name]) {
+			sel = i
+		}
+		if n := len([]rune(formatValue(v))); n > width {
+			width = n
+		}
+	}
+	width += 4
+	height := len(set)
+	if max := u.listHeight(); height > max {
+		height = max
+	}
+	// Place the list below the option, or above it if there is no room.
+	r0 := 1 + u.sel - u.top + 1
+	if r0+height > 1+u.listHeight() {
+		r0 = 1 + u.listHeight() - height
+	}
+	col := 2 + titleWidth + 1
+	if col+width > t.width {
+		col = t.width - width
+	}
+	top := 0
+	for {
+		if sel < top {
+			top = sel
+		}
+		if sel >= top+height {
+			top = sel - height + 1
+		}
+		for i := 0; i < height; i++ {
+			attr := attrBold
+			if top+i == sel {
+				attr = attrReverse
+			}
+			t.move(r0+i, col)
+			t.out.WriteString(attr + pad(" "+formatValue(set[top+i]), width) + attrNormal)
+		}
+		t.out.Flush()
+		k, err := t.readKey()
+		if err != nil {
+			return nil, false
+		}
+		switch k {
+		case keyUp, 'k':
+			if sel > 0 {
+				sel--
+			}
+		case keyDown, 'j':
+			if sel < len(set)-1 {
+				sel++
+			}
+		case keyEnter, ' ':
+			return set[sel], true
+		case keyEscape, keyInterrupt, 'q':
+			return nil, false
+		}
+	}
+}
+
+// preview runs a preview scan and shows it until a key is pressed.
+func (u *tui) preview() {
+	t := u.t
+	t.line(t.height-2, attrNormal, "Scanning preview...")
+	t.out.Flush()
+	p, err := u.c.Preview(context.Background())
+	u.c.OptionsDiff() // the preview restores the options it changed
+	u.load()
+	if err != nil {
+		u.msg = "Preview failed: " + err.Error()
+		return
+	}
+	t.clear()
+	t.line(0, attrReverse, " Preview of "+u.c.Device)
+	t.move(1, 0)
+	if u.sixel {
+		if err := writeSixel(t.out, p, t.width*cellWidth, (t.height-2)*cellHeight); err != nil {
+			u.msg = err.Error()
+			return
+		}
+	} else {
+		for i, s := range asciiArt(p, t.width, t.height-2) {
+			t.line(1+i, attrNormal, s)
+		}
+	}
+	t.line(t.height-1, attrReverse, "Press any key to return")
+	t.out.Flush()
+	t.readKey()
+}
+
+// save saves the current settings as a profile.
+func (u *tui) save() {
+	name, ok := u.prompt("Save profile to: ", u.profile)
+	if !ok || name == "" {
+		return
+	}
+	p, err := u.c.Profile()
+	if err == nil {
+		err = writeProfile(name, p)
+	}
+	if err != nil {
+		u.msg = "Save failed: " + err.Error()
+		return
+	}
+	u.profile = name
+	u.msg = "Saved profile to " + name
+}
@@ -0,0 +1,131 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package daemon runs scans in response to the buttons of a scanner, like
+// scanbd does.
+//
+// A Daemon watches the buttons of a connection with sane.Conn.WatchButtons,
+// and runs the action of the first rule matching each event. The usual
+// action, returned by Scan, applies a profile, scans and sends the pages to
+// a sink.
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"github.com/tjgq/sane"
+	"github.com/tjgq/sane/sink"
+	"time"
+)
+
+// DefaultInterval is the polling interval used when none is given.
+const DefaultInterval = 250 * time.Millisecond
+
+// An Action is run in response to a button event. It has exclusive use of
+// the connection while it runs.
+type Action func(ctx context.Context, c *sane.Conn, ev sane.ButtonEvent) error
+
+// A Rule maps button events to an action.
+type Rule struct {
+	Button string      // name of the sensor option, such as scan or email
+	Value  interface{} // value that triggers the rule, or nil for any press
+	Action Action      // action to run
+}
+
+// matches reports whether the rule applies to ev.
+func (r *Rule) matches(ev sane.ButtonEvent) bool {
+	if ev.Name != r.Button {
+		return false
+	}
+	if r.Value == nil {
+		return ev.Pressed()
+	}
+	// Compare the printed values, so that rules decoded from JSON, where
+	// numbers are float64, match int options.
+	return fmt.Sprint(ev.Value) == fmt.Sprint(r.Value)
+}
+
+// A Daemon runs actions in response to button events.
+type Daemon struct {
+	Conn      *sane.Conn                           // connection to the device
+	Interval  time.Duration                        // polling interval; DefaultInterval if zero
+	Rules     []Rule                               // rules, in order of precedence
+	ErrorFunc func(ev sane.ButtonEvent, err error) // called when an action fails; if nil, Run returns the error
+}
+
+// Run watches the buttons and runs the matching actions until ctx is done
+// or, if ErrorFunc is nil, an action fails. Buttons are not watched while an
+// action runs, so presses in the meantime are ignored.
+func (d *Daemon) Run(ctx context.Context) error {
+	for {
+		ev, r, err := d.next(ctx)
+		if err != nil {
+			return err
+		}
+		if err := r.Action(ctx, d.Conn, ev); err != nil {
+			if d.ErrorFunc == nil {
+				return fmt.Errorf("%s: %v", ev.Name, err)
+			}
+			d.ErrorFunc(ev, err)
+		}
+	}
+}
+
+// next waits for an event matching one of the rules, and returns it along
+// with the rule. The connection is no longer watched when it returns.
+func (d *Daemon) next(ctx context.Context) (sane.ButtonEvent, *Rule, error) {
+	interval := d.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ch, err := d.Conn.WatchButtons(wctx, interval)
+	if err != nil {
+		return sane.ButtonEvent{}, nil, err
+	}
+	for ev := range ch {
+		for i := range d.Rules {
+			if r := &d.Rules[i]; r.matches(ev) {
+				cancel()
+				for range ch {
+					// Wait for the watcher to stop using the connection.
+				}
+				return ev, r, nil
+			}
+		}
+	}
+	return sane.ButtonEvent{}, nil, ctx.Err()
+}
+
+// Scan returns an action that applies profile p, if not nil, then scans one
+// page or, if batch is true, every page in the document feeder, and sends
+// the pages to s.
+func Scan(p sane.Profile, batch bool, s sink.Sink) Action {
+	return func(ctx context.Context, c *sane.Conn, ev sane.ButtonEvent) error {
+		if p != nil {
+			if err := c.ApplyProfile(p); err != nil {
+				return err
+			}
+		}
+		doc := &sink.Document{Device: c.Device, Time: time.Now()}
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			m, err := c.ReadImage()
+			if err == sane.ErrEmpty && batch && len(doc.Pages) > 0 {
+				break // feeder ran out of paper
+			}
+			if err != nil {
+				return err
+			}
+			doc.Pages = append(doc.Pages, m)
+			if !batch {
+				break
+			}
+		}
+		return s.Send(ctx, doc)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package daemon
+
+import (
+	"context"
+	"github.com/tjgq/sane"
+	"github.com/tjgq/sane/sink"
+	"testing"
+	"time"
+)
+
+func TestMatches(t *testing.T) {
+	cases := []struct {
+		rule  Rule
+		ev    sane.ButtonEvent
+		match bool
+	}{
+		{Rule{Button: "scan"}, sane.ButtonEvent{Name: "scan", Value: true, Old: false}, true},
+		{Rule{Button: "scan"}, sane.ButtonEvent{Name: "scan", Value: false, Old: true}, false},
+		{Rule{Button: "scan"}, sane.ButtonEvent{Name: "email", Value: true, Old: false}, false},
+		{Rule{Button: "function"}, sane.ButtonEvent{Name: "function", Value: 2, Old: 0}, true},
+		{Rule{Button: "function", Value: 2.0}, sane.ButtonEvent{Name: "function", Value: 2, Old: 1}, true},
+		{Rule{Button: "function", Value: 3}, sane.ButtonEvent{Name: "function", Value: 2, Old: 1}, false},
+	}
+	for _, c := range cases {
+		if m := c.rule.matches(c.ev); m != c.match {
+			t.Errorf("rule %+v matches event %+v: %v, expected %v", c.rule, c.ev, m, c.match)
+		}
+	}
+}
+
+type sinkFunc func(ctx context.Context, d *sink.Document) error
+
+func (f sinkFunc) Send(ctx context.Context, d *sink.Document) error {
+	return f(ctx, d)
+}
+
+func TestScan(t *testing.T) {
+	if err := sane.Init(); err != nil {
+		t.Fatal("init failed:", err)
+	}
+	defer sane.Exit()
+	c, err := sane.Open("test")
+	if err != nil {
+		t.Fatal("open failed:", err)
+	}
+	defer c.Close()
+
+	var doc *sink.Document
+	s := sinkFunc(func(ctx context.Context, d *sink.Document) error {
+		doc = d
+		return nil
+	})
+	p := sane.Profile{"mode": "Gray", "source": "Automatic Document Feeder"}
+	ev := sane.ButtonEvent{Name: "scan", Value: true, Old: false}
+	if err := Scan(p, true, s)(context.Background(), c, ev); err != nil {
+		t.Fatal("scan failed:", err)
+	}
+	if doc == nil || len(doc.Pages) != 10 || doc.Device != "test" {
+		t.Errorf("bad document %+v", doc)
+	}
+}
+
+func TestRun(t *testing.T) {
+	if err := sane.Init(); err != nil {
+		t.Fatal("init failed:", err)
+	}
+	defer sane.Exit()
+	c, err := sane.Open("test")
+	if err != nil {
+		t.Fatal("open failed:", err)
+	}
+	defer c.Close()
+	if _, err := c.SetOption("enable-test-options", true); err != nil {
+		t.Fatal("set option failed:", err)
+	}
+
+	// The test device's sensors never change, so Run waits until the
+	// context is done.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	d := &Daemon{Conn: c, Interval: 5 * time.Millisecond, Rules: []Rule{{
+		Button: "bool-soft-detect",
+		Action: func(context.Context, *sane.Conn, sane.ButtonEvent) error { return nil },
+	}}}
+	if err := d.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("run returned %v", err)
+	}
+}
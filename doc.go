@@ -29,11 +29,21 @@
 //
 //   i, err := c.ReadImage()
 //
+// Pixel access through the image.Image interface is slow. Call Native to
+// convert the image to the matching standard library type before encoding it.
+//
+//   n := i.Native()
+//
 // Although ReadImage blocks, you may interrupt a scan in progress by calling
 // Cancel from another goroutine.
 //
 //   c.Cancel()
 //
+// To monitor a scan in progress, for example to display a progress bar, set
+// the connection's ProgressFunc before calling ReadImage.
+//
+//   c.ProgressFunc = func(p sane.Progress) { ... }
+//
 // Additional images may be scanned while the connection is open. To close the
 // connection, call Close.
 //
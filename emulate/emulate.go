@@ -0,0 +1,320 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package emulate provides software emulation of common scanning options.
+//
+// A Conn wraps a sane.Conn and adds options whose names start with "sw-",
+// such as sw-brightness or sw-deskew. They are reported by Options with
+// IsEmulated set, and can be read and set like the backend options. Instead
+// of being passed to the backend, they configure post-processing that is
+// applied to the images returned by ReadImage.
+//
+// An emulated option is inactive while the backend has an active, settable
+// option with the same purpose, which should be used instead.
+package emulate
+
+import (
+	"fmt"
+	"github.com/tjgq/sane"
+	"github.com/tjgq/sane/process"
+	"image"
+	"image/color"
+)
+
+// Group of the emulated options.
+const Group = "Software emulation"
+
+// An emulated describes an emulated option.
+type emulated struct {
+	sane.Option
+	def      interface{}           // default value
+	replaces func(*sane.Conn) bool // whether the backend has an equivalent
+}
+
+// backendHas returns a function reporting whether the backend has an
+// active, settable option with one of the given names.
+func backendHas(names ...string) func(*sane.Conn) bool {
+	return func(c *sane.Conn) bool {
+		for _, o := range c.Options() {
+			for _, name := range names {
+				if o.Name == name && o.IsActive && o.IsSettable {
+					return true
+				}
+			}
+		}
+		return false
+	}
+}
+
+// backendHasGray reports whether the backend can scan in grayscale.
+func backendHasGray(c *sane.Conn) bool {
+	for _, o := range c.Options() {
+		if o.Name != "mode" || !o.IsActive || !o.IsSettable {
+			continue
+		}
+		for _, v := range o.ConstrSet {
+			if v == "Gray" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var options = []emulated{
+	{sane.Option{
+		Name:  "sw-brightness",
+		Title: "Brightness",
+		Desc:  "Controls the brightness of the acquired image.",
+		Type:  sane.TypeInt,
+		Unit:  sane.UnitPercent,
+		ConstrRange: &sane.Range{
+			Min: -100, Max: 100, Quant: 1},
+	}, 0, backendHas("brightness")},
+	{sane.Option{
+		Name:  "sw-contrast",
+		Title: "Contrast",
+		Desc:  "Controls the contrast of the acquired image.",
+		Type:  sane.TypeInt,
+		Unit:  sane.UnitPercent,
+		ConstrRange: &sane.Range{
+			Min: -100, Max: 100, Quant: 1},
+	}, 0, backendHas("contrast")},
+	{sane.Option{
+		Name:  "sw-gamma",
+		Title: "Gamma",
+		Desc:  "Gamma correction applied to the acquired image. Values above 1 make midtones lighter.",
+		Type:  sane.TypeFloat,
+		ConstrRange: &sane.Range{
+			Min: 0.1, Max: 5.0, Quant: 0.0},
+	}, 1.0, backendHas("custom-gamma", "analog-gamma", "gamma-value")},
+	{sane.Option{
+		Name:  "sw-gray",
+		Title: "Grayscale",
+		Desc:  "Converts color images to grayscale.",
+		Type:  sane.TypeBool,
+	}, false, backendHasGray},
+	{sane.Option{
+		Name:  "sw-deskew",
+		Title: "Deskew",
+		Desc:  "Straightens pages that were fed at an angle.",
+		Type:  sane.TypeBool,
+	}, false, backendHas("deskew", "swdeskew")},
+	{sane.Option{
+		Name:  "sw-crop",
+		Title: "Crop to paper",
+		Desc:  "Removes the scanner backing around the paper.",
+		Type:  sane.TypeBool,
+	}, false, backendHas("autocrop", "swcrop")},
+}
+
+// find returns the named emulated option, or nil if there is none.
+func find(name string) *emulated {
+	for i := range options {
+		if options[i].Name == name {
+			return &options[i]
+		}
+	}
+	return nil
+}
+
+// Conn is a connection to a scanning device with emulated options.
+//
+// Only ReadImage applies the emulated options; the other methods of the
+// embedded sane.Conn, such as ReadFrame, return the data from the backend.
+type Conn struct {
+	*sane.Conn
+	values map[string]interface{}
+}
+
+// Wrap returns a Conn that adds emulated options to c. All emulated options
+// initially have values that leave the image unchanged.
+func Wrap(c *sane.Conn) *Conn {
+	w := &Conn{Conn: c, values: make(map[string]interface{})}
+	for _, e := range options {
+		w.values[e.Name] = e.def
+	}
+	return w
+}
+
+// option returns the descriptor of e for this connection.
+func (c *Conn) option(e *emulated) sane.Option {
+	o := e.Option
+	o.Group = Group
+	o.Length = 1
+	o.IsActive = !e.replaces(c.Conn)
+	o.IsSettable = true
+	o.IsEmulated = true
+	return o
+}
+
+// Options returns the backend options followed by the emulated ones.
+func (c *Conn) Options() []sane.Option {
+	opts := append([]sane.Option(nil), c.Conn.Options()...)
+	for i := range options {
+		opts = append(opts, c.option(&options[i]))
+	}
+	return opts
+}
+
+// GetOption gets the current value for the named option.
+func (c *Conn) GetOption(name string) (interface{}, error) {
+	if e := find(name); e != nil {
+		if !c.option(e).IsActive {
+			return nil, sane.ErrInvalid
+		}
+		return c.values[name], nil
+	}
+	return c.Conn.GetOption(name)
+}
+
+// SetOption sets the value of the named option. Values of emulated options
+// outside their range are clamped, and info.Inexact is set.
+func (c *Conn) SetOption(name string, v interface{}) (info sane.Info, err error) {
+	e := find(name)
+	if e == nil {
+		return c.Conn.SetOption(name, v)
+	}
+	if !c.option(e).IsActive {
+		return info, sane.ErrInvalid
+	}
+	switch e.Type {
+	case sane.TypeBool:
+		if _, ok := v.(bool); !ok {
+			return info, fmt.Errorf("option %s expects bool arg", name)
+		}
+	case sane.TypeInt:
+		n, ok := v.(int)
+		if !ok {
+			return info, fmt.Errorf("option %s expects int arg", name)
+		}
+		min, max := e.ConstrRange.Min.(int), e.ConstrRange.Max.(int)
+		switch {
+		case n < min:
+			v, info.Inexact = min, true
+		case n > max:
+			v, info.Inexact = max, true
+		}
+	case sane.TypeFloat:
+		f, ok := v.(float64)
+		if !ok {
+			return info, fmt.Errorf("option %s expects float64 arg", name)
+		}
+		min, max := e.ConstrRange.Min.(float64), e.ConstrRange.Max.(float64)
+		switch {
+		case f < min:
+			v, info.Inexact = min, true
+		case f > max:
+			v, info.Inexact = max, true
+		}
+	}
+	c.values[name] = v
+	return info, nil
+}
+
+// value returns the value of the named option, or its default if the option
+// is inactive.
+func (c *Conn) value(name string) interface{} {
+	e := find(name)
+	if !c.option(e).IsActive {
+		return e.def
+	}
+	return c.values[name]
+}
+
+// ReadImage reads an image from the connection and applies the emulated
+// options to it, in the following order: deskewing, cropping, conversion to
+// grayscale and tone adjustment. Lineart images remain lineart, while images
+// with more than 8 bits per sample keep their depth.
+func (c *Conn) ReadImage() (*sane.Image, error) {
+	m, err := c.Conn.ReadImage()
+	if err != nil {
+		return nil, err
+	}
+	a := process.Adjustment{
+		Gamma:      c.value("sw-gamma").(float64),
+		Brightness: float64(c.value("sw-brightness").(int)) / 100,
+		Contrast:   float64(c.value("sw-contrast").(int)) / 100,
+	}
+	adjust := a != process.Adjustment{Gamma: 1}
+	deskew, crop := c.value("sw-deskew").(bool), c.value("sw-crop").(bool)
+	gray := c.value("sw-gray").(bool)
+	if !adjust && !deskew && !crop && !gray {
+		return m, nil
+	}
+	lineart := isLineart(m)
+
+	if deskew {
+		m = fromImage(process.Deskew(m, process.DefaultDeskewOptions), m)
+	}
+	if crop {
+		r := process.PaperBounds(m)
+		if r != m.Bounds() {
+			m = fromImage(process.Crop(m, r), m)
+			m.Area = sane.Region{} // no longer known
+		}
+	}
+	if gray {
+		m = process.Gray(m)
+	}
+	if adjust {
+		m = process.Adjust(m, a)
+	}
+	if lineart && !isLineart(m) {
+		m = process.Threshold(m, 0.5)
+	}
+	return m, nil
+}
+
+// isLineart reports whether m has only black and white pixels.
+func isLineart(m *sane.Image) bool {
+	if m.ColorModel() != color.GrayModel {
+		return false
+	}
+	g := m.ToGray()
+	for _, v := range g.Pix {
+		if v != 0 && v != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// fromImage converts the result of a geometric transformation of m back into
+// a *sane.Image with the same resolution and scan area.
+func fromImage(src image.Image, m *sane.Image) *sane.Image {
+	format, depth := sane.Format(sane.FrameRgb), 8
+	switch src.ColorModel() {
+	case color.GrayModel:
+		format = sane.FrameGray
+	case color.Gray16Model:
+		format, depth = sane.FrameGray, 16
+	case color.RGBA64Model:
+		depth = 16
+	}
+	b := src.Bounds()
+	f, err := sane.NewFrame(format, b.Dx(), b.Dy(), depth)
+	if err != nil {
+		panic(err) // format and depth are always valid
+	}
+	shift := uint(16 - depth)
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, _ := src.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			if format == sane.FrameGray {
+				f.Set(x, y, 0, uint16(r>>shift))
+				continue
+			}
+			f.Set(x, y, 0, uint16(r>>shift))
+			f.Set(x, y, 1, uint16(g>>shift))
+			f.Set(x, y, 2, uint16(bl>>shift))
+		}
+	}
+	r, err := sane.NewImage(f)
+	if err != nil {
+		panic(err)
+	}
+	r.XRes, r.YRes, r.Area = m.XRes, m.YRes, m.Area
+	return r
+}
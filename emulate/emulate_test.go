@@ -0,0 +1,83 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package emulate
+
+import (
+	"github.com/tjgq/sane"
+	"image/color"
+	"testing"
+)
+
+func runTest(t *testing.T, f func(c *Conn)) {
+	if err := sane.Init(); err != nil {
+		t.Fatal("init failed:", err)
+	}
+	defer sane.Exit()
+	c, err := sane.Open("test")
+	if err != nil {
+		t.Fatal("open failed:", err)
+	}
+	defer c.Close()
+	f(Wrap(c))
+}
+
+func TestOptions(t *testing.T) {
+	runTest(t, func(c *Conn) {
+		active := make(map[string]bool)
+		for _, o := range c.Options() {
+			if o.Group == Group {
+				if !o.IsEmulated {
+					t.Errorf("option %s is not emulated", o.Name)
+				}
+				active[o.Name] = o.IsActive
+			}
+		}
+		if len(active) != len(options) {
+			t.Fatalf("got %d emulated options, expected %d", len(active), len(options))
+		}
+		if !active["sw-brightness"] {
+			t.Errorf("sw-brightness is inactive")
+		}
+		if active["sw-gray"] {
+			t.Errorf("sw-gray is active, but the backend supports gray mode")
+		}
+		if _, err := c.SetOption("sw-gray", true); err != sane.ErrInvalid {
+			t.Errorf("set inactive option returned %v", err)
+		}
+		info, err := c.SetOption("sw-brightness", 150)
+		if err != nil || !info.Inexact {
+			t.Fatalf("set out-of-range value returned %v, %v", info, err)
+		}
+		if v, _ := c.GetOption("sw-brightness"); v != 100 {
+			t.Errorf("value was clamped to %v", v)
+		}
+		if _, err := c.SetOption("sw-contrast", 0.5); err == nil {
+			t.Errorf("set option with wrong type succeeded")
+		}
+	})
+}
+
+func TestReadImage(t *testing.T) {
+	runTest(t, func(c *Conn) {
+		if _, err := c.SetOption("mode", "Gray"); err != nil {
+			t.Fatal("set mode failed:", err)
+		}
+		if _, err := c.SetOption("sw-brightness", 100); err != nil {
+			t.Fatal("set brightness failed:", err)
+		}
+		m, err := c.ReadImage()
+		if err != nil {
+			t.Fatal("read image failed:", err)
+		}
+		b := m.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if c := color.GrayModel.Convert(m.At(x, y)); c != (color.Gray{0xff}) {
+					t.Fatalf("pixel at (%d,%d) is %v after maximum brightness", x, y, c)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+)
+
+const metersPerInch = 0.0254
+
+// EncodePNG writes the image to w in PNG format. If the image's resolution is
+// known, it is recorded in a pHYs chunk.
+func EncodePNG(w io.Writer, m *Image) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, m.Native()); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if m.XRes <= 0 || m.YRes <= 0 {
+		_, err := w.Write(data)
+		return err
+	}
+
+	// The pHYs chunk must precede the image data. Insert it right after the
+	// signature and the IHDR chunk, which is always 25 bytes long.
+	const ihdrEnd = 8 + 25
+	if len(data) < ihdrEnd {
+		return errors.New("sane: short PNG data")
+	}
+	var chunk [4 + 4 + 9 + 4]byte // length, type, data, CRC
+	binary.BigEndian.PutUint32(chunk[0:], 9)
+	copy(chunk[4:], "pHYs")
+	binary.BigEndian.PutUint32(chunk[8:], uint32(math.Floor(m.XRes/metersPerInch+0.5)))
+	binary.BigEndian.PutUint32(chunk[12:], uint32(math.Floor(m.YRes/metersPerInch+0.5)))
+	chunk[16] = 1 // unit is the meter
+	binary.BigEndian.PutUint32(chunk[17:], crc32.ChecksumIEEE(chunk[4:17]))
+
+	for _, b := range [][]byte{data[:ihdrEnd], chunk[:], data[ihdrEnd:]} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncodeJPEG writes the image to w in JPEG format. If the image was received
+// from the device as JPEG data, that data is written as is; otherwise, the
+// image is encoded with the given options. If the image's resolution is known,
+// it is recorded in a JFIF header.
+func EncodeJPEG(w io.Writer, m *Image, o *jpeg.Options) error {
+	data, mt := m.Encoded()
+	if mt != "image/jpeg" {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, m.Native(), o); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+	if m.XRes > 0 && m.YRes > 0 {
+		data = setJFIFDensity(data, m.XRes, m.YRes)
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// setJFIFDensity sets the pixel density in a JFIF header, adding the header
+// if there is none. It returns the modified data.
+func setJFIFDensity(data []byte, xres, yres float64) []byte {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return data // not a JPEG file
+	}
+	x := uint16(math.Min(math.Floor(xres+0.5), 0xffff))
+	y := uint16(math.Min(math.Floor(yres+0.5), 0xffff))
+
+	// An existing header must immediately follow the SOI marker.
+	if len(data) >= 20 && data[2] == 0xff && data[3] == 0xe0 &&
+		bytes.Equal(data[6:11], []byte("JFIF\x00")) {
+		out := append([]byte(nil), data...)
+		out[13] = 1 // density unit is the inch
+		binary.BigEndian.PutUint16(out[14:], x)
+		binary.BigEndian.PutUint16(out[16:], y)
+		return out
+	}
+
+	app0 := []byte{
+		0xff, 0xe0, 0x00, 0x10, // APP0 marker and length
+		'J', 'F', 'I', 'F', 0x00, // identifier
+		0x01, 0x02, // version 1.02
+		0x01,                  // density unit is the inch
+		byte(x >> 8), byte(x), // horizontal density
+		byte(y >> 8), byte(y), // vertical density
+		0x00, 0x00, // no thumbnail
+	}
+	out := make([]byte, 0, len(data)+len(app0))
+	out = append(out, data[:2]...)
+	out = append(out, app0...)
+	return append(out, data[2:]...)
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+// MIME types of the data in frames that do not hold raw samples.
+var mimeTypes = map[Format]string{
+	FrameText: "text/plain",
+	FrameJpeg: "image/jpeg",
+	FrameG31D: "image/g3fax",
+	FrameG32D: "image/g3fax",
+	FrameG42D: "image/x-ccitt-g4",
+	FrameXml:  "application/xml",
+}
+
+// readEncodedFrame reads a frame holding compressed data.
+func (c *Conn) readEncodedFrame(p Params, mimeType string) (*Frame, error) {
+	data := new(bytes.Buffer)
+	if _, err := data.ReadFrom(newProgressReader(c, p)); err != nil {
+		return nil, err
+	}
+	f := &Frame{
+		Format:   p.Format,
+		Width:    p.PixelsPerLine,
+		Height:   p.Lines,
+		Depth:    p.Depth,
+		IsLast:   p.IsLast,
+		Encoded:  data.Bytes(),
+		MimeType: mimeType}
+	if p.Format == FrameJpeg {
+		// The parameters may be inaccurate; trust the JPEG header instead.
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(f.Encoded))
+		if err != nil {
+			return nil, err
+		}
+		f.Width, f.Height = cfg.Width, cfg.Height
+		f.Channels = 3
+		if cfg.ColorModel == color.GrayModel {
+			f.Channels = 1
+		}
+	}
+	return f, nil
+}
+
+// Encoded returns the compressed data the image was received as, and its
+// MIME type. It returns a nil slice if the image was received uncompressed.
+// Encoders may embed this data as is, avoiding a lossy recompression.
+func (m *Image) Encoded() ([]byte, string) {
+	if m.encoded == nil {
+		return nil, ""
+	}
+	return m.encoded.Encoded, m.encoded.MimeType
+}
+
+// Decode decompresses an image that was received compressed. This happens
+// automatically the first time the image's pixels are accessed, but calling
+// Decode explicitly allows decoding errors to be detected; when one occurs,
+// the image is left blank.
+func (m *Image) Decode() error {
+	m.frames()
+	return m.err
+}
+
+// frames returns the frames of the image, decoding them first if needed.
+func (m *Image) frames() *[4]*Frame {
+	if m.encoded != nil {
+		m.once.Do(m.decode)
+	}
+	return &m.fs
+}
+
+func (m *Image) decode() {
+	e := m.encoded
+	d, err := jpeg.Decode(bytes.NewReader(e.Encoded))
+	if err != nil {
+		m.err = err
+		m.fs[0] = newFrame(FrameGray, e.Width, e.Height, 1, 8, true)
+		return
+	}
+	b := d.Bounds()
+	if g, ok := d.(*image.Gray); ok {
+		f := newFrame(FrameGray, b.Dx(), b.Dy(), 1, 8, true)
+		for y := 0; y < f.Height; y++ {
+			copy(f.data[y*f.bytesPerLine:], g.Pix[y*g.Stride:y*g.Stride+f.Width])
+		}
+		m.fs[0] = f
+		return
+	}
+	f := newFrame(FrameRgb, b.Dx(), b.Dy(), 3, 8, true)
+	for y := 0; y < f.Height; y++ {
+		p := f.data[y*f.bytesPerLine:]
+		for x := 0; x < f.Width; x++ {
+			r, g, bl, _ := d.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			p[3*x], p[3*x+1], p[3*x+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+		}
+	}
+	m.fs[0] = f
+}
@@ -17,6 +17,8 @@ type Frame struct {
 	Channels     int    // number of channels
 	Depth        int    // bits per sample
 	IsLast       bool   // whether this is the last frame
+	Encoded      []byte // compressed data, for frames that do not hold samples
+	MimeType     string // MIME type of the compressed data
 	bytesPerLine int    // bytes per line, including any padding
 	data         []byte // raw data
 }
@@ -32,8 +34,19 @@ func (c *Conn) ReadFrame() (*Frame, error) {
 		return nil, err
 	}
 
-	if p.Depth != 1 && p.Depth != 8 && p.Depth != 16 {
-		return nil, fmt.Errorf("unsupported bit depth: %d", p.Depth)
+	return c.readFrame(p)
+}
+
+// readFrame reads a frame that has already been started and whose parameters
+// are p.
+func (c *Conn) readFrame(p Params) (*Frame, error) {
+	if mt, ok := mimeTypes[p.Format]; ok {
+		return c.readEncodedFrame(p, mt)
+	}
+
+	nch := channels(p.Format)
+	if nch == 0 || !supportedDepth(p.Depth) {
+		return nil, &FormatError{p.Format, p.Depth}
 	}
 
 	data := new(bytes.Buffer)
@@ -42,15 +55,10 @@ func (c *Conn) ReadFrame() (*Frame, error) {
 		data = bytes.NewBuffer(make([]byte, 0, p.Lines*p.BytesPerLine))
 	}
 
-	if _, err := data.ReadFrom(c); err != nil {
+	if _, err := data.ReadFrom(newProgressReader(c, p)); err != nil {
 		return nil, err
 	}
 
-	nch := 1
-	if p.Format == FrameRgb {
-		nch = 3
-	}
-
 	return &Frame{
 		Format:       p.Format,
 		Width:        p.PixelsPerLine,
@@ -62,25 +70,240 @@ func (c *Conn) ReadFrame() (*Frame, error) {
 		data:         data.Bytes()}, nil
 }
 
+// channels returns the number of channels in a frame of the given format,
+// or 0 if the format does not hold raw samples.
+func channels(format Format) int {
+	switch format {
+	case FrameGray, FrameRed, FrameGreen, FrameBlue, FrameIr:
+		return 1
+	case FrameGrayi:
+		return 2
+	case FrameRgb:
+		return 3
+	case FrameRgbi:
+		return 4
+	}
+	return 0
+}
+
+// supportedDepth reports whether samples of the given bit depth can be read.
+// Depths above 8 are stored in 16 bits.
+func supportedDepth(depth int) bool {
+	switch depth {
+	case 1, 4, 8, 10, 12, 14, 16:
+		return true
+	}
+	return false
+}
+
+// isGray reports whether the frame holds a grayscale image.
+func (f *Frame) isGray() bool {
+	return f.Format == FrameGray || f.Format == FrameGrayi
+}
+
+// colorChannels returns the number of channels in the frame, not counting
+// any infrared channel.
+func (f *Frame) colorChannels() int {
+	switch f.Format {
+	case FrameGrayi, FrameRgbi:
+		return f.Channels - 1
+	case FrameIr:
+		return 0
+	}
+	return f.Channels
+}
+
 // At returns the sample at coordinates (x,y) for channel ch.
 // Note that values are not normalized to the uint16 range,
 // so you need to interpret them relative to the color depth.
 func (f *Frame) At(x, y, ch int) uint16 {
-	switch f.Depth {
-	case 1:
+	switch {
+	case f.Depth == 1:
 		i := f.bytesPerLine*y + f.Channels*(x/8) + ch
 		s := (f.data[i] >> uint8(x%8)) & 0x01
-		if f.Format == FrameGray {
+		if f.isGray() && ch == 0 {
 			// For B&W lineart, 0 is white and 1 is black
 			return uint16(s ^ 0x1)
 		}
 		return uint16(s)
-	case 8:
+	case f.Depth == 4:
+		// The first sample is in the high nibble.
+		j := f.Channels*x + ch
+		s := f.data[f.bytesPerLine*y+j/2]
+		if j%2 == 0 {
+			s >>= 4
+		}
+		return uint16(s & 0x0f)
+	case f.Depth == 8:
 		i := f.bytesPerLine*y + f.Channels*x + ch
 		return uint16(f.data[i])
-	case 16:
+	case f.Depth <= 16:
+		// Samples of less than 16 bits are stored in the low bits.
 		i := f.bytesPerLine*y + 2*(f.Channels*x+ch)
-		return uint16(f.data[i+1])<<8 + uint16(f.data[i])
+		s := uint16(f.data[i+1])<<8 + uint16(f.data[i])
+		return s & uint16(1<<uint(f.Depth)-1)
 	}
 	return 0
 }
+
+// Set sets the sample at coordinates (x,y) for channel ch.
+// Like the values returned by At, v is relative to the color depth.
+func (f *Frame) Set(x, y, ch int, v uint16) {
+	switch {
+	case f.Depth == 1:
+		if f.isGray() && ch == 0 {
+			// For B&W lineart, 0 is white and 1 is black
+			v ^= 0x1
+		}
+		i := f.bytesPerLine*y + f.Channels*(x/8) + ch
+		m := byte(1) << uint8(x%8)
+		if v&0x01 != 0 {
+			f.data[i] |= m
+		} else {
+			f.data[i] &^= m
+		}
+	case f.Depth == 4:
+		j := f.Channels*x + ch
+		i := f.bytesPerLine*y + j/2
+		if j%2 == 0 {
+			f.data[i] = f.data[i]&0x0f | byte(v&0x0f)<<4
+		} else {
+			f.data[i] = f.data[i]&0xf0 | byte(v&0x0f)
+		}
+	case f.Depth == 8:
+		f.data[f.bytesPerLine*y+f.Channels*x+ch] = byte(v)
+	case f.Depth <= 16:
+		v &= uint16(1<<uint(f.Depth) - 1)
+		i := f.bytesPerLine*y + 2*(f.Channels*x+ch)
+		f.data[i], f.data[i+1] = byte(v), byte(v>>8)
+	}
+}
+
+// at16 is like At, but scales the sample to the uint16 range.
+func (f *Frame) at16(x, y, ch int) uint16 {
+	s := uint32(f.At(x, y, ch))
+	switch f.Depth {
+	case 1:
+		return uint16(s * 0xffff)
+	case 4:
+		return uint16(s * 0x1111)
+	case 8:
+		return uint16(s * 0x101)
+	case 16:
+		return uint16(s)
+	}
+	return uint16(s * 0xffff / (1<<uint(f.Depth) - 1))
+}
+
+// NewFrame returns a frame of the given format, size and bit depth, whose
+// samples can be filled in with Set. The frame is initially white for
+// lineart and black otherwise, and is marked as the last one in its image.
+func NewFrame(format Format, width, height, depth int) (*Frame, error) {
+	nch := channels(format)
+	if nch == 0 || !supportedDepth(depth) {
+		return nil, &FormatError{format, depth}
+	}
+	if width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("sane: invalid frame size %dx%d", width, height)
+	}
+	return newFrame(format, width, height, nch, depth, true), nil
+}
+
+// newFrame returns a frame with the given properties and zeroed, unpadded
+// data.
+func newFrame(format Format, width, height, channels, depth int, isLast bool) *Frame {
+	var bpl int
+	switch {
+	case depth == 1:
+		bpl = channels * ((width + 7) / 8)
+	case depth <= 8:
+		bpl = (width*channels*depth + 7) / 8
+	default:
+		bpl = 2 * width * channels
+	}
+	return &Frame{
+		Format:       format,
+		Width:        width,
+		Height:       height,
+		Channels:     channels,
+		Depth:        depth,
+		IsLast:       isLast,
+		bytesPerLine: bpl,
+		data:         make([]byte, bpl*height)}
+}
+
+// transform returns a new frame of the given size, where the pixel at (x,y)
+// is copied from the pixel at src(x,y) in f.
+func (f *Frame) transform(width, height int, src func(x, y int) (int, int)) *Frame {
+	g := newFrame(f.Format, width, height, f.Channels, f.Depth, f.IsLast)
+	if f.Depth == 1 {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				sx, sy := src(x, y)
+				for ch := 0; ch < f.Channels; ch++ {
+					s := f.data[f.bytesPerLine*sy+f.Channels*(sx/8)+ch]
+					if (s>>uint8(sx%8))&0x01 != 0 {
+						g.data[g.bytesPerLine*y+g.Channels*(x/8)+ch] |= 1 << uint8(x%8)
+					}
+				}
+			}
+		}
+		return g
+	}
+	if f.Depth == 4 {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				sx, sy := src(x, y)
+				for ch := 0; ch < f.Channels; ch++ {
+					s := byte(f.At(sx, sy, ch))
+					j := g.Channels*x + ch
+					if j%2 == 0 {
+						s <<= 4
+					}
+					g.data[g.bytesPerLine*y+j/2] |= s
+				}
+			}
+		}
+		return g
+	}
+	n := f.Channels // bytes per pixel
+	if f.Depth > 8 {
+		n *= 2
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx, sy := src(x, y)
+			i := g.bytesPerLine*y + n*x
+			j := f.bytesPerLine*sy + n*sx
+			copy(g.data[i:i+n], f.data[j:j+n])
+		}
+	}
+	return g
+}
+
+// Rotate returns a copy of the frame rotated clockwise by deg degrees, which
+// must be a multiple of 90.
+func (f *Frame) Rotate(deg int) (*Frame, error) {
+	w, h := f.Width, f.Height
+	switch (deg%360 + 360) % 360 {
+	case 0:
+		return f.transform(w, h, func(x, y int) (int, int) { return x, y }), nil
+	case 90:
+		return f.transform(h, w, func(x, y int) (int, int) { return y, h - 1 - x }), nil
+	case 180:
+		return f.transform(w, h, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }), nil
+	case 270:
+		return f.transform(h, w, func(x, y int) (int, int) { return w - 1 - y, x }), nil
+	}
+	return nil, fmt.Errorf("rotation by %d degrees is not a multiple of 90", deg)
+}
+
+// Flip returns a mirrored copy of the frame. If horizontal is true, the
+// left and right sides are swapped; otherwise, the top and bottom are.
+func (f *Frame) Flip(horizontal bool) *Frame {
+	w, h := f.Width, f.Height
+	if horizontal {
+		return f.transform(w, h, func(x, y int) (int, int) { return w - 1 - x, y })
+	}
+	return f.transform(w, h, func(x, y int) (int, int) { return x, h - 1 - y })
+}
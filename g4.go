@@ -0,0 +1,175 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+// CCITT Group 4 (ITU-T T.6) encoding of bilevel images.
+
+// Run length codes from ITU-T T.4, tables 2 and 3. The first 64 codes are
+// terminating codes for runs of 0 to 63 pixels; the rest are makeup codes for
+// runs of 64 to 1728 pixels, in steps of 64.
+var whiteCodes = []string{
+	"00110101", "000111", "0111", "1000", "1011", "1100", "1110", "1111",
+	"10011", "10100", "00111", "01000", "001000", "000011", "110100", "110101",
+	"101010", "101011", "0100111", "0001100", "0001000", "0010111", "0000011", "0000100",
+	"0101000", "0101011", "0010011", "0100100", "0011000", "00000010", "00000011", "00011010",
+	"00011011", "00010010", "00010011", "00010100", "00010101", "00010110", "00010111", "00101000",
+	"00101001", "00101010", "00101011", "00101100", "00101101", "00000100", "00000101", "00001010",
+	"00001011", "01010010", "01010011", "01010100", "01010101", "00100100", "00100101", "01011000",
+	"01011001", "01011010", "01011011", "01001010", "01001011", "00110010", "00110011", "00110100",
+	"11011", "10010", "010111", "0110111", "00110110", "00110111", "01100100", "01100101",
+	"01101000", "01100111", "011001100", "011001101", "011010010", "011010011", "011010100", "011010101",
+	"011010110", "011010111", "011011000", "011011001", "011011010", "011011011", "010011000", "010011001",
+	"010011010", "011000", "010011011",
+}
+
+var blackCodes = []string{
+	"0000110111", "010", "11", "10", "011", "0011", "0010", "00011",
+	"000101", "000100", "0000100", "0000101", "0000111", "00000100", "00000111", "000011000",
+	"0000010111", "0000011000", "0000001000", "00001100111", "00001101000", "00001101100", "00000110111", "00000101000",
+	"00000010111", "00000011000", "000011001010", "000011001011", "000011001100", "000011001101", "000001101000", "000001101001",
+	"000001101010", "000001101011", "000011010010", "000011010011", "000011010100", "000011010101", "000011010110", "000011010111",
+	"000001101100", "000001101101", "000011011010", "000011011011", "000001010100", "000001010101", "000001010110", "000001010111",
+	"000001100100", "000001100101", "000001010010", "000001010011", "000000100100", "000000110111", "000000111000", "000000100111",
+	"000000101000", "000001011000", "000001011001", "000000101011", "000000101100", "000001011010", "000001100110", "000001100111",
+	"0000001111", "000011001000", "000011001001", "000001011011", "000000110011", "000000110100", "000000110101", "0000001101100",
+	"0000001101101", "0000001001010", "0000001001011", "0000001001100", "0000001001101", "0000001110010", "0000001110011", "0000001110100",
+	"0000001110101", "0000001110110", "0000001110111", "0000001010010", "0000001010011", "0000001010100", "0000001010101", "0000001011010",
+	"0000001011011", "0000001100100", "0000001100101",
+}
+
+// Makeup codes shared by both colors, for runs of 1792 to 2560 pixels in
+// steps of 64, from ITU-T T.4 table 4.
+var extMakeupCodes = []string{
+	"00000001000", "00000001100", "00000001101", "000000010010", "000000010011",
+	"000000010100", "000000010101", "000000010110", "000000010111", "000000011100",
+	"000000011101", "000000011110", "000000011111",
+}
+
+// Mode codes from ITU-T T.6 table 1.
+const (
+	g4Pass       = "0001"
+	g4Horizontal = "001"
+	g4EOL        = "000000000001"
+)
+
+// Vertical mode codes, indexed by a1-b1+3.
+var g4Vertical = []string{"0000010", "000010", "010", "1", "011", "000011", "0000011"}
+
+// g4Writer accumulates bits, most significant bit first.
+type g4Writer struct {
+	out  []byte
+	acc  uint32 // pending bits, in the low nacc bits
+	nacc uint
+}
+
+func (w *g4Writer) code(s string) {
+	for i := 0; i < len(s); i++ {
+		w.acc = w.acc<<1 | uint32(s[i]-'0')
+		if w.nacc++; w.nacc == 8 {
+			w.out = append(w.out, byte(w.acc))
+			w.acc, w.nacc = 0, 0
+		}
+	}
+}
+
+// run writes the codes for a run of n pixels of the given color.
+func (w *g4Writer) run(n int, black bool) {
+	codes := whiteCodes
+	if black {
+		codes = blackCodes
+	}
+	for n >= 2560+64 {
+		w.code(extMakeupCodes[len(extMakeupCodes)-1])
+		n -= 2560
+	}
+	if n >= 1792 {
+		w.code(extMakeupCodes[n/64-28])
+		n %= 64
+	} else if n >= 64 {
+		w.code(codes[63+n/64])
+		n %= 64
+	}
+	w.code(codes[n])
+}
+
+// flush pads the output to a byte boundary and returns it.
+func (w *g4Writer) flush() []byte {
+	if w.nacc > 0 {
+		w.out = append(w.out, byte(w.acc<<(8-w.nacc)))
+		w.acc, w.nacc = 0, 0
+	}
+	return w.out
+}
+
+// encodeG4 compresses a bilevel image with the given width and height. Rows
+// are packed most significant bit first and padded to a byte boundary, with
+// 1 meaning black.
+func encodeG4(data []byte, width, height int) []byte {
+	bpl := (width + 7) / 8
+	ref := make([]byte, bpl) // imaginary white line above the image
+	w := &g4Writer{}
+	for y := 0; y < height; y++ {
+		cur := data[y*bpl : (y+1)*bpl]
+		encodeG4Row(w, cur, ref, width)
+		ref = cur
+	}
+	w.code(g4EOL) // end of facsimile block
+	w.code(g4EOL)
+	return w.flush()
+}
+
+// pixel reports whether pixel i in row is black. Pixels to the left of the
+// row are white.
+func pixel(row []byte, i int) bool {
+	return i >= 0 && row[i/8]&(0x80>>uint(i%8)) != 0
+}
+
+// nextChange returns the position of the first changing element at or after
+// position i, or width if there is none. A changing element is a pixel of a
+// different color than the one to its left.
+func nextChange(row []byte, i, width int) int {
+	for ; i < width; i++ {
+		if pixel(row, i) != pixel(row, i-1) {
+			return i
+		}
+	}
+	return width
+}
+
+func encodeG4Row(w *g4Writer, cur, ref []byte, width int) {
+	a0, black := -1, false
+	for a0 < width {
+		a1 := nextChange(cur, a0+1, width)
+		b1 := nextChange(ref, a0+1, width)
+		for b1 < width && pixel(ref, b1) == black {
+			b1 = nextChange(ref, b1+1, width)
+		}
+		b2 := width
+		if b1 < width {
+			b2 = nextChange(ref, b1+1, width)
+		}
+		switch {
+		case b2 < a1:
+			w.code(g4Pass)
+			a0 = b2
+		case a1-b1 >= -3 && a1-b1 <= 3:
+			w.code(g4Vertical[a1-b1+3])
+			a0, black = a1, !black
+		default:
+			a2 := width
+			if a1 < width {
+				a2 = nextChange(cur, a1+1, width)
+			}
+			start := a0
+			if start < 0 {
+				start = 0
+			}
+			w.code(g4Horizontal)
+			w.run(a1-start, black)
+			w.run(a2-a1, !black)
+			a0 = a2
+		}
+	}
+}
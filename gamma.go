@@ -0,0 +1,223 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Number of samples in the curves built by GammaCurve and SplineCurve.
+const curveSamples = 1024
+
+// A Curve is a tone curve, such as the contents of a gamma table. It holds
+// output levels from 0 to 1 for evenly spaced input levels from 0 to 1, the
+// first sample being for input 0 and the last for input 1.
+type Curve []float64
+
+// A CurvePoint is a control point of a tone curve.
+type CurvePoint struct {
+	In, Out float64 // input and output levels, from 0 to 1
+}
+
+// GammaCurve returns the curve for the given gamma value. Values above 1
+// make midtones lighter.
+func GammaCurve(gamma float64) Curve {
+	cv := make(Curve, curveSamples)
+	for i := range cv {
+		cv[i] = math.Pow(float64(i)/(curveSamples-1), 1/gamma)
+	}
+	return cv
+}
+
+// SplineCurve returns a smooth curve through the given control points. The
+// curve is monotonic between the points, and flat outside them. With no
+// points, the curve is the identity.
+func SplineCurve(points ...CurvePoint) Curve {
+	if len(points) == 0 {
+		return GammaCurve(1)
+	}
+	ps := append([]CurvePoint(nil), points...)
+	sort.Slice(ps, func(i, j int) bool { return ps[i].In < ps[j].In })
+	n := len(ps)
+
+	// Compute the tangents with the Fritsch-Carlson method, which avoids
+	// overshooting.
+	d := make([]float64, n) // slopes of the segments
+	m := make([]float64, n) // tangents at the points
+	for i := 0; i < n-1; i++ {
+		if dx := ps[i+1].In - ps[i].In; dx > 0 {
+			d[i] = (ps[i+1].Out - ps[i].Out) / dx
+		}
+	}
+	for i := range m {
+		switch {
+		case i == 0:
+			m[i] = d[0]
+		case i == n-1:
+			m[i] = d[n-2]
+		case d[i-1]*d[i] > 0:
+			m[i] = (d[i-1] + d[i]) / 2
+		}
+	}
+	for i := 0; i < n-1; i++ {
+		if d[i] == 0 {
+			m[i], m[i+1] = 0, 0
+			continue
+		}
+		a, b := m[i]/d[i], m[i+1]/d[i]
+		if s := a*a + b*b; s > 9 {
+			t := 3 / math.Sqrt(s)
+			m[i], m[i+1] = t*a*d[i], t*b*d[i]
+		}
+	}
+
+	cv := make(Curve, curveSamples)
+	k := 0
+	for i := range cv {
+		x := float64(i) / (curveSamples - 1)
+		for k < n-1 && x > ps[k+1].In {
+			k++
+		}
+		var y float64
+		switch {
+		case x <= ps[0].In:
+			y = ps[0].Out
+		case k == n-1:
+			y = ps[n-1].Out
+		default:
+			// Cubic Hermite interpolation between points k and k+1.
+			h := ps[k+1].In - ps[k].In
+			t := (x - ps[k].In) / h
+			t2, t3 := t*t, t*t*t
+			y = (2*t3-3*t2+1)*ps[k].Out + (t3-2*t2+t)*h*m[k] +
+				(-2*t3+3*t2)*ps[k+1].Out + (t3-t2)*h*m[k+1]
+		}
+		cv[i] = math.Max(0, math.Min(1, y))
+	}
+	return cv
+}
+
+// At returns the output level of the curve for input level x, interpolating
+// between samples.
+func (cv Curve) At(x float64) float64 {
+	if len(cv) == 0 {
+		return x
+	}
+	f := math.Max(0, math.Min(1, x)) * float64(len(cv)-1)
+	i := int(f)
+	if i >= len(cv)-1 {
+		return cv[len(cv)-1]
+	}
+	return cv[i] + (f-float64(i))*(cv[i+1]-cv[i])
+}
+
+// tableRange returns the range of values in the gamma table o. If the option
+// has no range constraint, the values are assumed to go from 0 to Length-1.
+func tableRange(o *Option) (min, max float64) {
+	if o.ConstrRange == nil {
+		return 0, float64(o.Length - 1)
+	}
+	min, _ = toFloat(o.ConstrRange.Min)
+	max, _ = toFloat(o.ConstrRange.Max)
+	return min, max
+}
+
+// Table resamples the curve into a value for the gamma table o, with
+// o.Length entries within the range constraint of the option. The result is
+// an []int or a []float64, according to the option type.
+func (cv Curve) Table(o *Option) interface{} {
+	min, max := tableRange(o)
+	vs := make([]float64, o.Length)
+	for i := range vs {
+		x := 0.0
+		if o.Length > 1 {
+			x = float64(i) / float64(o.Length-1)
+		}
+		vs[i] = closest(o, min+cv.At(x)*(max-min))
+	}
+	if o.Type == TypeFloat {
+		return vs
+	}
+	is := make([]int, len(vs))
+	for i, v := range vs {
+		is[i] = int(math.Floor(v + 0.5))
+	}
+	return is
+}
+
+// GammaTable reads the gamma table in the named option, such as gamma-table
+// or red-gamma-table, as a curve that can be edited and passed to
+// SetGammaTable.
+func (c *Conn) GammaTable(name string) (Curve, error) {
+	o := c.findOption(name)
+	if o == nil {
+		return nil, fmt.Errorf("no option named %s", name)
+	}
+	if o.Type != TypeInt && o.Type != TypeFloat {
+		return nil, fmt.Errorf("option %s is not a gamma table", name)
+	}
+	v, err := c.GetOption(name)
+	if err != nil {
+		return nil, err
+	}
+	var vs []float64
+	switch v := v.(type) {
+	case []int:
+		for _, n := range v {
+			vs = append(vs, float64(n))
+		}
+	case []float64:
+		vs = v
+	default:
+		f, _ := toFloat(v)
+		vs = []float64{f}
+	}
+	min, max := tableRange(o)
+	cv := make(Curve, len(vs))
+	for i, f := range vs {
+		if max > min {
+			cv[i] = math.Max(0, math.Min(1, (f-min)/(max-min)))
+		}
+	}
+	return cv, nil
+}
+
+// SetGammaTable sets the gamma table in the named option to the given curve,
+// resampled as by Curve.Table. If the device has a custom-gamma option, it is
+// enabled first, since most backends ignore their gamma tables otherwise;
+// the returned info then includes its effects.
+func (c *Conn) SetGammaTable(name string, cv Curve) (info Info, err error) {
+	if o := c.settable("custom-gamma"); o != nil && o.Type == TypeBool {
+		if v, err := c.GetOption(o.Name); err == nil && v != true {
+			if info, err = c.SetOption(o.Name, true); err != nil {
+				return info, err
+			}
+		}
+	}
+	o := c.settable(name)
+	if o == nil {
+		return info, fmt.Errorf("option %s cannot be set", name)
+	}
+	if o.Type != TypeInt && o.Type != TypeFloat {
+		return info, fmt.Errorf("option %s is not a gamma table", name)
+	}
+	v := cv.Table(o)
+	if o.Length == 1 {
+		// Not a table, but set it anyway for consistency.
+		switch t := v.(type) {
+		case []int:
+			v = t[0]
+		case []float64:
+			v = t[0]
+		}
+	}
+	i, err := c.SetOption(name, v)
+	info.Inexact = info.Inexact || i.Inexact
+	info.ReloadOpts = info.ReloadOpts || i.ReloadOpts
+	info.ReloadParams = info.ReloadParams || i.ReloadParams
+	return info, err
+}
@@ -0,0 +1,3 @@
+module github.com/tjgq/sane
+
+go 1.16
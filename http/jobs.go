@@ -0,0 +1,377 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/tjgq/sane"
+	"github.com/tjgq/sane/sink"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// State is the state of a scan job.
+type State string
+
+// State constants.
+const (
+	Running   State = "running"   // scan in progress
+	Done      State = "done"      // image available
+	Failed    State = "failed"    // scan failed
+	Cancelled State = "cancelled" // scan cancelled by a client
+)
+
+// A Job is a scan in progress or completed.
+type Job struct {
+	ID     string // job identifier
+	Device string // device name
+	Sink   string // name of the sink receiving the images, if any
+
+	sink sink.Sink          // sink receiving the images, if any
+	time time.Time          // when the job started
+	ctx  context.Context    // context for the delivery to the sink
+	stop context.CancelFunc // cancels ctx
+
+	mu        sync.Mutex
+	conn      *sane.Conn    // connection, while scanning
+	state     State         // current state
+	progress  sane.Progress // latest progress report
+	err       error         // error, if failed
+	images    []*sane.Image // scanned images
+	area      *sane.Region  // scan area, for previews
+	waiting   bool          // whether waiting for paper
+	cancelled bool          // whether Cancel was called
+	finished  time.Time     // when the job finished, if not running
+	done      chan struct{} // closed when the scan ends
+}
+
+// JobStatus is the JSON representation of a job.
+type JobStatus struct {
+	ID       string   `json:"id"`
+	Device   string   `json:"device"`
+	Sink     string   `json:"sink,omitempty"`
+	State    State    `json:"state"`
+	Waiting  bool     `json:"waiting,omitempty"` // waiting for paper
+	Page     int      `json:"page"`
+	Frame    int      `json:"frame"`
+	Bytes    int      `json:"bytes"`
+	Total    int      `json:"total"`
+	Fraction *float64 `json:"fraction"` // null if unknown
+	Pages    int      `json:"pages"`    // images scanned so far
+	Area     *area    `json:"area,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// area is the JSON representation of a scan area.
+type area struct {
+	TLX  float64   `json:"tlx"`
+	TLY  float64   `json:"tly"`
+	BRX  float64   `json:"brx"`
+	BRY  float64   `json:"bry"`
+	Unit sane.Unit `json:"unit"`
+}
+
+// Status returns the current status of the job.
+func (j *Job) Status() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	st := JobStatus{
+		ID:      j.ID,
+		Device:  j.Device,
+		Sink:    j.Sink,
+		State:   j.state,
+		Waiting: j.waiting,
+		Page:    j.progress.Page,
+		Frame:   j.progress.Frame,
+		Bytes:   j.progress.Bytes,
+		Total:   j.progress.Total,
+	}
+	if f, ok := j.progress.Fraction(); ok {
+		st.Fraction = &f
+	}
+	if j.state == Done {
+		f := 1.0
+		st.Fraction = &f
+	}
+	st.Pages = len(j.images)
+	if a := j.area; a != nil {
+		st.Area = &area{a.TLX, a.TLY, a.BRX, a.BRY, a.Unit}
+	}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	return st
+}
+
+// Cancel cancels the job if it is running.
+func (j *Job) Cancel() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.state == Running {
+		j.cancelled = true
+		if j.conn != nil {
+			j.conn.Cancel()
+		}
+		j.stop()
+	}
+}
+
+// Wait waits for the job to finish.
+func (j *Job) Wait() {
+	<-j.done
+}
+
+// run calls scan with the connection to the device, which must be locked,
+// and releases the device when done. The scan function stores the images in
+// the job as they are read. If the job has a sink, the images are then sent
+// to it.
+func (j *Job) run(d *device, scan func(c *sane.Conn) error) {
+	defer close(j.done)
+	defer j.stop()
+	c := d.conn
+	c.ProgressFunc = func(p sane.Progress) {
+		j.mu.Lock()
+		j.progress = p
+		j.mu.Unlock()
+	}
+	err := scan(c)
+	c.ProgressFunc = nil
+	j.mu.Lock()
+	j.conn = nil
+	ms := j.images
+	j.mu.Unlock()
+	d.release()
+
+	if err == nil && j.sink != nil {
+		doc := &sink.Document{Device: j.Device, Time: j.time, Pages: ms}
+		if err = j.sink.Send(j.ctx, doc); err != nil {
+			err = fmt.Errorf("send to %s: %v", j.Sink, err)
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.finished = time.Now()
+	switch {
+	case err == nil:
+		j.state = Done
+	case j.cancelled:
+		j.state = Cancelled
+	default:
+		j.state, j.err = Failed, err
+	}
+}
+
+// setWaiting records whether the job is waiting for paper.
+func (j *Job) setWaiting(w bool) {
+	j.mu.Lock()
+	j.waiting = w
+	j.mu.Unlock()
+}
+
+// add stores an image read by the job.
+func (j *Job) add(m *sane.Image) {
+	j.mu.Lock()
+	j.images = append(j.images, m)
+	j.mu.Unlock()
+}
+
+// start starts a job on the named device, calling scan as described for
+// Job.run. If sinkName is not empty, the images are sent to the named sink.
+func (s *Server) start(name, sinkName string, scan func(j *Job, c *sane.Conn) error) (*Job, error) {
+	var k sink.Sink
+	if sinkName != "" {
+		s.mu.Lock()
+		k = s.sinks[sinkName]
+		s.mu.Unlock()
+		if k == nil {
+			return nil, &httpError{http.StatusBadRequest, "no sink named " + sinkName}
+		}
+	}
+	d, err := s.device(name)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.nextID++
+	j := &Job{
+		ID:     strconv.Itoa(s.nextID),
+		Device: name,
+		Sink:   sinkName,
+		sink:   k,
+		time:   time.Now(),
+		conn:   d.conn,
+		state:  Running,
+		done:   make(chan struct{}),
+	}
+	j.ctx, j.stop = context.WithCancel(context.Background())
+	s.evict()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+	go j.run(d, func(c *sane.Conn) error { return scan(j, c) })
+	return j, nil
+}
+
+// scan starts a job scanning one image from the named device or, if batch is
+// true, as many images as the document feeder holds, and sending them to the
+// named sink, if any. If wait is true, the job first waits for paper to be
+// placed in the feeder.
+func (s *Server) scan(name string, batch, wait bool, sinkName string) (*Job, error) {
+	return s.start(name, sinkName, func(j *Job, c *sane.Conn) error {
+		if wait {
+			j.setWaiting(true)
+			err := c.WaitForPaper(j.ctx)
+			j.setWaiting(false)
+			if err != nil {
+				return err
+			}
+		}
+		for {
+			// A cancellation between pages finds the device idle, so
+			// sane_cancel does not stop the next page.
+			if err := j.ctx.Err(); err != nil {
+				return err
+			}
+			m, err := c.ReadImage()
+			if err == sane.ErrEmpty && batch && len(j.images) > 0 {
+				return nil // feeder ran out of paper
+			}
+			if err != nil {
+				return err
+			}
+			j.add(m)
+			if !batch {
+				return nil
+			}
+		}
+	})
+}
+
+// preview starts a job making a preview scan of the named device.
+func (s *Server) preview(name string) (*Job, error) {
+	return s.start(name, "", func(j *Job, c *sane.Conn) error {
+		p, err := c.Preview(j.ctx)
+		if err != nil {
+			return err
+		}
+		j.mu.Lock()
+		j.area = &p.Area
+		j.mu.Unlock()
+		j.add(p.Image)
+		return nil
+	})
+}
+
+// job returns the job with the given ID, or nil if there is none.
+func (s *Server) job(id string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict()
+	return s.jobs[id]
+}
+
+// evict discards the jobs that finished more than s.JobTTL ago, so that
+// their images do not stay in memory forever. It must be called with s.mu
+// held.
+func (s *Server) evict() {
+	ttl := s.JobTTL
+	if ttl == 0 {
+		ttl = DefaultJobTTL
+	}
+	for id, j := range s.jobs {
+		j.mu.Lock()
+		expired := j.state != Running && time.Since(j.finished) > ttl
+		j.mu.Unlock()
+		if expired {
+			delete(s.jobs, id)
+		}
+	}
+}
+
+// deleteJob cancels j if it is running, or discards it otherwise.
+func (s *Server) deleteJob(j *Job) {
+	j.mu.Lock()
+	running := j.state == Running
+	j.mu.Unlock()
+	if running {
+		j.Cancel()
+		return
+	}
+	s.mu.Lock()
+	delete(s.jobs, j.ID)
+	s.mu.Unlock()
+}
+
+// Image encoders by format name, with their MIME types. Encoders for a
+// single image use the page chosen by the client.
+var encoders = map[string]struct {
+	mimeType string
+	encode   func(io.Writer, []*sane.Image) error
+}{
+	"png": {"image/png", func(w io.Writer, ms []*sane.Image) error {
+		return sane.EncodePNG(w, ms[0])
+	}},
+	"jpeg": {"image/jpeg", func(w io.Writer, ms []*sane.Image) error {
+		return sane.EncodeJPEG(w, ms[0], nil)
+	}},
+	"tiff": {"image/tiff", func(w io.Writer, ms []*sane.Image) error {
+		tw := sane.NewTIFFWriter(w)
+		for _, m := range ms {
+			if err := tw.AddPage(m); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+	}},
+	"pdf": {"application/pdf", sane.EncodePDF},
+}
+
+// result writes the images scanned by the job with the given ID, in the
+// given format. The PNG and JPEG formats hold only the page with the given
+// index, while the TIFF and PDF formats hold all pages.
+func (s *Server) result(w http.ResponseWriter, id, format, page string) error {
+	j := s.job(id)
+	if j == nil {
+		return errNoJob
+	}
+	if format == "" {
+		format = "png"
+	}
+	enc, ok := encoders[format]
+	if !ok {
+		return &httpError{http.StatusBadRequest, "unsupported format " + format}
+	}
+	j.mu.Lock()
+	ms, state := j.images, j.state
+	j.mu.Unlock()
+	if state != Done {
+		return &httpError{http.StatusConflict, "job is " + string(state)}
+	}
+	if format == "png" || format == "jpeg" {
+		n := 0
+		if page != "" {
+			var err error
+			if n, err = strconv.Atoi(page); err != nil || n < 0 || n >= len(ms) {
+				return &httpError{http.StatusNotFound, "no such page"}
+			}
+		}
+		ms = ms[n : n+1]
+	}
+
+	// Encode to memory first, so that errors can still be reported.
+	var buf bytes.Buffer
+	if err := enc.encode(&buf, ms); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", enc.mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	buf.WriteTo(w) // too late to report errors
+	return nil
+}
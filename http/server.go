@@ -0,0 +1,371 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package http implements an HTTP service for scanning.
+//
+// The service exposes the following resources, using JSON for requests and
+// responses unless noted otherwise:
+//
+//	GET    /devices                   list the available devices
+//	GET    /sinks                     list the names of the available sinks
+//	GET    /devices/{name}/options    option descriptors
+//	GET    /devices/{name}/values     option values, with null for inactive options
+//	PATCH  /devices/{name}/values     set the options in a JSON object
+//	GET    /devices/{name}/status     state of the sensors
+//	POST   /devices/{name}/scans      start a scan, returning a job
+//	POST   /devices/{name}/preview    start a preview scan, returning a job
+//	GET    /jobs/{id}                 job status and progress
+//	DELETE /jobs/{id}                 cancel a running job, or discard a finished one
+//	GET    /jobs/{id}/result          scanned images
+//	GET    /ui/                       web interface (HTML)
+//
+// Device names must be escaped as path segments. A scan with the batch query
+// parameter set to true reads pages until the document feeder is empty. A
+// scan with the sink query parameter sends its pages to the named sink, added
+// with Server.AddSink, before the job is done; if that fails, the job fails,
+// but the pages can still be retrieved. A batch scan with the wait query
+// parameter set to true first waits for paper to be placed in the feeder. The
+// format of the result is chosen with the format query parameter, which may
+// be png (the default), jpeg, tiff or pdf; for png and jpeg, the page query
+// parameter selects the page, starting at 0. The status of a preview job
+// includes the scan area covered by the image.
+//
+// Each device is driven by at most one request or job at a time; requests for
+// a device that is busy fail with status 409 (Conflict). Finished jobs are
+// discarded after Server.JobTTL, unless a client deletes them first. Connections are
+// opened on first use and kept open until the server is closed. The caller is
+// responsible for calling sane.Init and sane.Exit.
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tjgq/sane"
+	"github.com/tjgq/sane/sink"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors reported to clients.
+var (
+	errBusy     = &httpError{http.StatusConflict, "device is busy"}
+	errNotFound = &httpError{http.StatusNotFound, "not found"}
+	errNoJob    = &httpError{http.StatusNotFound, "no such job"}
+)
+
+// An httpError is an error with an HTTP status code.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func (e *httpError) Error() string {
+	return e.msg
+}
+
+// A device is an open connection, along with a lock that guards its use.
+type device struct {
+	conn *sane.Conn
+	sem  chan struct{} // holds a value while the connection is in use
+}
+
+// acquire locks the device, failing if it is already locked.
+func (d *device) acquire() error {
+	select {
+	case d.sem <- struct{}{}:
+		return nil
+	default:
+		return errBusy
+	}
+}
+
+// release unlocks the device.
+func (d *device) release() {
+	<-d.sem
+}
+
+// DefaultJobTTL is how long finished jobs are kept by default.
+const DefaultJobTTL = time.Hour
+
+// Server is an HTTP handler serving the scanning service.
+type Server struct {
+	JobTTL time.Duration // how long finished jobs and their images are kept; DefaultJobTTL if zero
+
+	mu      sync.Mutex
+	devices map[string]*device   // open devices by name
+	jobs    map[string]*Job      // jobs by ID
+	sinks   map[string]sink.Sink // sinks by name
+	nextID  int                  // ID of the next job
+}
+
+// NewServer returns a new server.
+func NewServer() *Server {
+	return &Server{
+		devices: make(map[string]*device),
+		jobs:    make(map[string]*Job),
+		sinks:   make(map[string]sink.Sink),
+	}
+}
+
+// AddSink makes a sink available to scan jobs under the given name.
+func (s *Server) AddSink(name string, k sink.Sink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sinks[name] = k
+}
+
+// sinkNames returns the names of the sinks, sorted.
+func (s *Server) sinkNames() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := []string{}
+	for name := range s.sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close cancels any running jobs and closes all connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		j.Cancel()
+	}
+	for name, d := range s.devices {
+		d.sem <- struct{}{} // wait for the device to be released
+		d.conn.Close()
+		delete(s.devices, name)
+	}
+}
+
+// device returns the named device, opening it if necessary, and locks it.
+func (s *Server) device(name string) (*device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.devices[name]
+	if !ok {
+		c, err := sane.Open(name)
+		if err != nil {
+			return nil, &httpError{http.StatusNotFound, err.Error()}
+		}
+		d = &device{conn: c, sem: make(chan struct{}, 1)}
+		s.devices[name] = d
+	}
+	if err := d.acquire(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ServeHTTP dispatches a request to the appropriate handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var path []string
+	for _, p := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		p, err := url.PathUnescape(p)
+		if err != nil {
+			writeError(w, &httpError{http.StatusBadRequest, err.Error()})
+			return
+		}
+		path = append(path, p)
+	}
+
+	var (
+		v   interface{}
+		err error
+	)
+	notAllowed := &httpError{http.StatusMethodNotAllowed, "method not allowed"}
+	switch {
+	case path[0] == "" || path[0] == "ui":
+		if r.Method != "GET" {
+			err = notAllowed
+			break
+		}
+		serveUI(w, r)
+		return
+	case len(path) == 1 && path[0] == "devices":
+		if r.Method != "GET" {
+			err = notAllowed
+			break
+		}
+		v, err = sane.Devices()
+	case len(path) == 1 && path[0] == "sinks":
+		if r.Method != "GET" {
+			err = notAllowed
+			break
+		}
+		v = s.sinkNames()
+	case len(path) == 3 && path[0] == "devices":
+		name := path[1]
+		switch {
+		case path[2] == "options" && r.Method == "GET":
+			v, err = s.options(name)
+		case path[2] == "values" && r.Method == "GET":
+			v, err = s.values(name)
+		case path[2] == "values" && r.Method == "PATCH":
+			v, err = s.setValues(name, r)
+		case path[2] == "status" && r.Method == "GET":
+			v, err = s.status(name)
+		case (path[2] == "scans" || path[2] == "preview") && r.Method == "POST":
+			var j *Job
+			if path[2] == "scans" {
+				q := r.URL.Query()
+				batch := q.Get("batch") == "true"
+				j, err = s.scan(name, batch, batch && q.Get("wait") == "true", q.Get("sink"))
+			} else {
+				j, err = s.preview(name)
+			}
+			if err == nil {
+				w.Header().Set("Location", "/jobs/"+j.ID)
+				writeJSON(w, http.StatusAccepted, j.Status())
+				return
+			}
+		case path[2] == "options" || path[2] == "values" || path[2] == "status" ||
+			path[2] == "scans" || path[2] == "preview":
+			err = notAllowed
+		default:
+			err = errNotFound
+		}
+	case len(path) == 2 && path[0] == "jobs":
+		j := s.job(path[1])
+		switch {
+		case j == nil:
+			err = errNoJob
+		case r.Method == "GET":
+			v = j.Status()
+		case r.Method == "DELETE":
+			s.deleteJob(j)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		default:
+			err = notAllowed
+		}
+	case len(path) == 3 && path[0] == "jobs" && path[2] == "result":
+		if r.Method != "GET" {
+			err = notAllowed
+			break
+		}
+		q := r.URL.Query()
+		if err = s.result(w, path[1], q.Get("format"), q.Get("page")); err == nil {
+			return
+		}
+	default:
+		err = errNotFound
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes an error response, with a JSON object holding the error
+// message.
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch e := err.(type) {
+	case *httpError:
+		code = e.code
+	default:
+		switch err {
+		case sane.ErrBusy:
+			code = http.StatusConflict
+		case sane.ErrInvalid:
+			code = http.StatusBadRequest
+		}
+	}
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+func (s *Server) options(name string) ([]sane.Option, error) {
+	d, err := s.device(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return d.conn.Options(), nil
+}
+
+func (s *Server) values(name string) (map[string]interface{}, error) {
+	d, err := s.device(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+	return values(d.conn), nil
+}
+
+func (s *Server) status(name string) (sane.Status, error) {
+	d, err := s.device(name)
+	if err != nil {
+		return sane.Status{}, err
+	}
+	defer d.release()
+	return d.conn.Status()
+}
+
+// values returns the option values of c, with nil for inactive options.
+func values(c *sane.Conn) map[string]interface{} {
+	vals := c.Values()
+	for name, v := range vals {
+		if v == sane.Inactive {
+			vals[name] = nil
+		}
+	}
+	return vals
+}
+
+// setValues sets the options in the JSON object in the request body, in
+// the order of the option list, and returns the resulting option values.
+func (s *Server) setValues(name string, r *http.Request) (map[string]interface{}, error) {
+	var req map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, &httpError{http.StatusBadRequest, err.Error()}
+	}
+	d, err := s.device(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.release()
+
+	// Set the options in the order in which the backend lists them, since
+	// later options may depend on earlier ones.
+	var names []string
+	for _, o := range d.conn.Options() {
+		if _, ok := req[o.Name]; ok {
+			names = append(names, o.Name)
+		}
+	}
+	if len(names) != len(req) {
+		return nil, &httpError{http.StatusBadRequest, "unknown option in request"}
+	}
+	for _, n := range names {
+		o, ok := d.conn.LookupOption(n)
+		if !ok {
+			return nil, &httpError{http.StatusBadRequest, fmt.Sprintf("option %s disappeared", n)}
+		}
+		v, err := sane.ConvertValue(o, req[n])
+		if err != nil {
+			return nil, &httpError{http.StatusBadRequest, err.Error()}
+		}
+		if _, err := d.conn.SetOption(n, v); err != nil {
+			return nil, fmt.Errorf("set option %s: %v", n, err)
+		}
+	}
+	return values(d.conn), nil
+}
@@ -0,0 +1,181 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/tjgq/sane"
+	"github.com/tjgq/sane/sink"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runTest(t *testing.T, f func(ts *httptest.Server)) {
+	if err := sane.Init(); err != nil {
+		t.Fatal("init failed:", err)
+	}
+	defer sane.Exit()
+	s := NewServer()
+	defer s.Close()
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	f(ts)
+}
+
+// do performs a request and decodes the JSON response into v, checking the
+// status code.
+func do(t *testing.T, method, url string, body interface{}, code int, v interface{}) {
+	var b bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&b).Encode(body)
+	}
+	req, err := http.NewRequest(method, url, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != code {
+		t.Fatalf("%s %s returned %s, expected %d", method, url, resp.Status, code)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s returned bad JSON: %v", method, url, err)
+		}
+	}
+}
+
+func TestOptions(t *testing.T) {
+	runTest(t, func(ts *httptest.Server) {
+		var devs []sane.Device
+		do(t, "GET", ts.URL+"/devices", nil, http.StatusOK, &devs)
+
+		var opts []sane.Option
+		do(t, "GET", ts.URL+"/devices/test/options", nil, http.StatusOK, &opts)
+		if len(opts) == 0 {
+			t.Fatal("no options")
+		}
+
+		var vals map[string]interface{}
+		do(t, "PATCH", ts.URL+"/devices/test/values",
+			map[string]interface{}{"mode": "Color", "enable-test-options": true},
+			http.StatusOK, &vals)
+		if vals["mode"] != "Color" || vals["enable-test-options"] != true {
+			t.Errorf("options not set: %v", vals)
+		}
+		do(t, "PATCH", ts.URL+"/devices/test/values",
+			map[string]interface{}{"no-such-option": 1}, http.StatusBadRequest, nil)
+		do(t, "PUT", ts.URL+"/devices/test/values", nil, http.StatusMethodNotAllowed, nil)
+		do(t, "GET", ts.URL+"/devices/no-such-device/options", nil, http.StatusNotFound, nil)
+	})
+}
+
+func TestScan(t *testing.T) {
+	runTest(t, func(ts *httptest.Server) {
+		var st JobStatus
+		do(t, "POST", ts.URL+"/devices/test/scans", nil, http.StatusAccepted, &st)
+		for st.State == Running {
+			time.Sleep(10 * time.Millisecond)
+			do(t, "GET", ts.URL+"/jobs/"+st.ID, nil, http.StatusOK, &st)
+		}
+		if st.State != Done {
+			t.Fatalf("job ended in state %s: %s", st.State, st.Error)
+		}
+
+		resp, err := http.Get(ts.URL + "/jobs/" + st.ID + "/result?format=png")
+		if err != nil {
+			t.Fatal("get result failed:", err)
+		}
+		defer resp.Body.Close()
+		if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+			t.Errorf("result has content type %s", ct)
+		}
+		if _, err := png.Decode(resp.Body); err != nil {
+			t.Errorf("bad PNG result: %v", err)
+		}
+
+		do(t, "GET", ts.URL+"/jobs/"+st.ID+"/result?format=gif", nil, http.StatusBadRequest, nil)
+		do(t, "GET", ts.URL+"/jobs/"+st.ID+"/result?format=png&page=1", nil, http.StatusNotFound, nil)
+		do(t, "DELETE", ts.URL+"/jobs/"+st.ID, nil, http.StatusNoContent, nil)
+		do(t, "GET", ts.URL+"/jobs/"+st.ID, nil, http.StatusNotFound, nil)
+	})
+}
+
+func TestSinks(t *testing.T) {
+	s := NewServer()
+	s.AddSink("b", &sink.Dir{})
+	s.AddSink("a", &sink.Dir{})
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	var names []string
+	do(t, "GET", ts.URL+"/sinks", nil, http.StatusOK, &names)
+	if strings.Join(names, ",") != "a,b" {
+		t.Errorf("sinks are %v, expected [a b]", names)
+	}
+	do(t, "POST", ts.URL+"/devices/test/scans?sink=c", nil, http.StatusBadRequest, nil)
+}
+
+func TestScanToSink(t *testing.T) {
+	runTest(t, func(ts *httptest.Server) {
+		dir := t.TempDir()
+		ts.Config.Handler.(*Server).AddSink("dir", &sink.Dir{Path: dir, Files: sink.Files{Name: "out.{{.Ext}}"}})
+		var st JobStatus
+		do(t, "POST", ts.URL+"/devices/test/scans?sink=dir", nil, http.StatusAccepted, &st)
+		for st.State == Running {
+			time.Sleep(10 * time.Millisecond)
+			do(t, "GET", ts.URL+"/jobs/"+st.ID, nil, http.StatusOK, &st)
+		}
+		if st.State != Done || st.Sink != "dir" {
+			t.Fatalf("job ended in state %s with sink %q: %s", st.State, st.Sink, st.Error)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "out.pdf")); err != nil {
+			t.Error("scan was not sent to the sink:", err)
+		}
+	})
+}
+
+func TestUI(t *testing.T) {
+	ts := httptest.NewServer(NewServer())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/")
+	if err != nil {
+		t.Fatal("get failed:", err)
+	}
+	defer resp.Body.Close()
+	if resp.Request.URL.Path != "/ui/" {
+		t.Errorf("/ redirected to %s, expected /ui/", resp.Request.URL.Path)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("/ui/ has content type %s", ct)
+	}
+	for _, f := range []string{"app.js", "style.css"} {
+		do(t, "GET", ts.URL+"/ui/"+f, nil, http.StatusOK, nil)
+	}
+	do(t, "GET", ts.URL+"/ui/missing.js", nil, http.StatusNotFound, nil)
+	do(t, "POST", ts.URL+"/ui/", nil, http.StatusMethodNotAllowed, nil)
+}
+
+func TestEvict(t *testing.T) {
+	s := NewServer()
+	s.JobTTL = time.Minute
+	s.jobs["1"] = &Job{ID: "1", state: Running}
+	s.jobs["2"] = &Job{ID: "2", state: Done, finished: time.Now()}
+	s.jobs["3"] = &Job{ID: "3", state: Failed, finished: time.Now().Add(-2 * time.Minute)}
+	for id, kept := range map[string]bool{"1": true, "2": true, "3": false} {
+		if j := s.job(id); (j != nil) != kept {
+			t.Errorf("job %s kept: %v, expected %v", id, j != nil, kept)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The web interface is a single page that drives the service through the
+// same API as any other client.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+var uiHandler = func() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/ui/", http.FileServer(http.FS(sub)))
+}()
+
+// serveUI serves the files of the web interface under /ui/, redirecting
+// other paths there.
+func serveUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/ui" || r.URL.Path == "/" {
+		http.Redirect(w, r, "ui/", http.StatusFound)
+		return
+	}
+	uiHandler.ServeHTTP(w, r)
+}
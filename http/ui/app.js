@@ -0,0 +1,377 @@
+// Web interface for the scan service. It uses the same API as any other
+// client, with URLs relative to the page.
+"use strict";
+
+const api = "../";
+const $ = (id) => document.getElementById(id);
+
+let device = "";
+let options = [];
+let previewArea = null; // scan area covered by the preview image
+let job = null;         // job being polled
+
+function deviceURL(path) {
+  return api + "devices/" + encodeURIComponent(device) + "/" + path;
+}
+
+async function request(method, url, body) {
+  const init = { method: method, headers: {} };
+  if (body !== undefined) {
+    init.headers["Content-Type"] = "application/json";
+    init.body = JSON.stringify(body);
+  }
+  const resp = await fetch(url, init);
+  if (resp.status === 204) {
+    return null;
+  }
+  const data = await resp.json();
+  if (!resp.ok) {
+    throw new Error(data.error || resp.statusText);
+  }
+  return data;
+}
+
+function setStatus(msg) {
+  $("status").textContent = msg;
+}
+
+async function loadDevices() {
+  const devs = await request("GET", api + "devices");
+  const sel = $("device");
+  sel.innerHTML = "";
+  for (const d of devs || []) {
+    const opt = document.createElement("option");
+    opt.value = d.name;
+    opt.textContent = `${d.vendor} ${d.model} (${d.name})`;
+    sel.appendChild(opt);
+  }
+  if (!devs || devs.length === 0) {
+    setStatus("No devices found.");
+    return;
+  }
+  device = sel.value;
+  await loadOptions();
+}
+
+async function loadSinks() {
+  const names = await request("GET", api + "sinks");
+  const sel = $("sink");
+  for (const n of names) {
+    const opt = document.createElement("option");
+    opt.value = n;
+    opt.textContent = n;
+    sel.appendChild(opt);
+  }
+  $("sink-label").hidden = names.length === 0;
+}
+
+async function loadOptions() {
+  options = await request("GET", deviceURL("options"));
+  const values = await request("GET", deviceURL("values"));
+  renderOptions(values);
+}
+
+// widget returns the input element for an option, chosen by its type and
+// constraint.
+function widget(o, v) {
+  let el;
+  if (o.type === "bool") {
+    el = document.createElement("input");
+    el.type = "checkbox";
+    el.checked = v === true;
+    el.onchange = () => setOption(o, el.checked);
+    return el;
+  }
+  if (o.length > 1) {
+    // Vector options, such as gamma tables, are edited as lists.
+    el = document.createElement("input");
+    el.type = "text";
+    el.value = Array.isArray(v) ? v.join(", ") : "";
+    el.onchange = () => setOption(o, el.value.split(",").map(Number));
+    return el;
+  }
+  if (o.constraintSet) {
+    el = document.createElement("select");
+    for (const c of o.constraintSet) {
+      const opt = document.createElement("option");
+      opt.value = c;
+      opt.textContent = c;
+      opt.selected = c === v;
+      el.appendChild(opt);
+    }
+    el.onchange = () => setOption(o, o.type === "string" ? el.value : Number(el.value));
+    return el;
+  }
+  if (o.constraintRange) {
+    const r = o.constraintRange;
+    const wrap = document.createElement("span");
+    const slider = document.createElement("input");
+    const num = document.createElement("input");
+    slider.type = "range";
+    num.type = "number";
+    for (const input of [slider, num]) {
+      input.min = r.min;
+      input.max = r.max;
+      input.step = r.quant > 0 ? r.quant : (o.type === "int" ? 1 : "any");
+      input.value = v === null ? r.min : v;
+    }
+    slider.oninput = () => { num.value = slider.value; };
+    slider.onchange = () => setOption(o, Number(slider.value));
+    num.onchange = () => setOption(o, Number(num.value));
+    wrap.append(slider, num);
+    wrap.inputs = [slider, num];
+    return wrap;
+  }
+  el = document.createElement("input");
+  el.type = o.type === "string" ? "text" : "number";
+  el.value = v === null ? "" : v;
+  el.onchange = () => setOption(o, o.type === "string" ? el.value : Number(el.value));
+  return el;
+}
+
+function renderOptions(values) {
+  const root = $("options");
+  root.innerHTML = "";
+  const groups = new Map();
+  for (const o of options) {
+    if (o.type === "button" || !o.settable) {
+      continue;
+    }
+    if (!groups.has(o.group)) {
+      const fs = document.createElement("fieldset");
+      const legend = document.createElement("legend");
+      legend.textContent = o.group || "Options";
+      fs.appendChild(legend);
+      root.appendChild(fs);
+      groups.set(o.group, fs);
+    }
+    const label = document.createElement("label");
+    label.className = "option" + (o.advanced ? " advanced" : "");
+    label.title = o.desc;
+    const title = document.createElement("span");
+    title.textContent = o.title + (o.unit !== "none" ? ` (${o.unit})` : "");
+    const w = widget(o, values[o.name]);
+    for (const input of w.inputs || [w]) {
+      input.disabled = !o.active;
+    }
+    label.append(title, w);
+    groups.get(o.group).appendChild(label);
+  }
+}
+
+async function setOption(o, v) {
+  if (o.type === "int") {
+    v = Array.isArray(v) ? v.map(Math.round) : Math.round(v);
+  }
+  try {
+    const values = await request("PATCH", deviceURL("values"), { [o.name]: v });
+    // Setting an option may change the others, so reload them all.
+    options = await request("GET", deviceURL("options"));
+    renderOptions(values);
+    setStatus("");
+  } catch (e) {
+    setStatus(`Cannot set ${o.title}: ${e.message}`);
+    await loadOptions();
+  }
+}
+
+function jobURL(id, path) {
+  return api + "jobs/" + encodeURIComponent(id) + (path ? "/" + path : "");
+}
+
+// runJob starts a job and polls it until it ends, showing its progress.
+async function runJob(url) {
+  setBusy(true);
+  try {
+    job = await request("POST", url);
+    const bar = $("progress");
+    bar.hidden = false;
+    while (job.state === "running") {
+      if (job.fraction === null) {
+        bar.removeAttribute("value");
+      } else {
+        bar.value = job.fraction;
+      }
+      setStatus(job.waiting ? "Waiting for paper…" : `Scanning page ${job.page + 1}…`);
+      await new Promise((resolve) => setTimeout(resolve, 250));
+      job = await request("GET", jobURL(job.id));
+    }
+    bar.hidden = true;
+    if (job.state !== "done") {
+      setStatus(`Scan ${job.state}${job.error ? ": " + job.error : ""}.`);
+      return null;
+    }
+    setStatus("");
+    return job;
+  } catch (e) {
+    setStatus(e.message);
+    return null;
+  } finally {
+    job = null;
+    setBusy(false);
+  }
+}
+
+function setBusy(busy) {
+  for (const id of ["preview", "scan", "batch", "wait", "sink", "device", "refresh"]) {
+    $(id).disabled = busy;
+  }
+  $("cancel").disabled = !busy;
+}
+
+async function preview() {
+  const j = await runJob(deviceURL("preview"));
+  if (!j) {
+    return;
+  }
+  previewArea = j.area;
+  const img = $("preview-image");
+  img.src = jobURL(j.id, "result") + "?format=png";
+  img.hidden = false;
+  $("crop").hidden = true;
+}
+
+async function scan(batch) {
+  const params = new URLSearchParams();
+  if (batch) {
+    params.set("batch", "true");
+    if ($("wait").checked) {
+      params.set("wait", "true");
+    }
+  }
+  if ($("sink").value) {
+    params.set("sink", $("sink").value);
+  }
+  const j = await runJob(deviceURL("scans") + "?" + params);
+  if (!j) {
+    return;
+  }
+  const li = document.createElement("li");
+  const stamp = new Date().toLocaleTimeString();
+  li.append(`${stamp}: ${j.pages} page${j.pages === 1 ? "" : "s"}` +
+    (j.sink ? ` sent to ${j.sink} ` : " "));
+  const link = (text, href) => {
+    const a = document.createElement("a");
+    a.href = href;
+    a.textContent = text;
+    a.download = "";
+    li.append(a, " ");
+  };
+  link("PDF", jobURL(j.id, "result") + "?format=pdf");
+  link("TIFF", jobURL(j.id, "result") + "?format=tiff");
+  for (let i = 0; i < j.pages; i++) {
+    link(`PNG ${i + 1}`, jobURL(j.id, "result") + `?format=png&page=${i}`);
+    link(`JPEG ${i + 1}`, jobURL(j.id, "result") + `?format=jpeg&page=${i}`);
+  }
+  $("results").prepend(li);
+}
+
+// Crop box dragging on the preview image.
+let dragStart = null;
+
+function imagePoint(e) {
+  const r = $("preview-image").getBoundingClientRect();
+  return {
+    x: Math.min(Math.max(e.clientX - r.left, 0), r.width),
+    y: Math.min(Math.max(e.clientY - r.top, 0), r.height),
+  };
+}
+
+function drawCrop(a, b) {
+  const box = $("crop");
+  box.style.left = Math.min(a.x, b.x) + "px";
+  box.style.top = Math.min(a.y, b.y) + "px";
+  box.style.width = Math.abs(a.x - b.x) + "px";
+  box.style.height = Math.abs(a.y - b.y) + "px";
+  box.hidden = false;
+}
+
+async function setArea(tlx, tly, brx, bry) {
+  // The geometry options are set in order, since their ranges depend on
+  // each other.
+  const names = ["tl-x", "tl-y", "br-x", "br-y"];
+  const vals = [tlx, tly, brx, bry];
+  const req = {};
+  names.forEach((n, i) => {
+    const o = options.find((o) => o.name === n);
+    if (o) {
+      req[n] = o.type === "int" ? Math.round(vals[i]) : vals[i];
+    }
+  });
+  try {
+    const values = await request("PATCH", deviceURL("values"), req);
+    options = await request("GET", deviceURL("options"));
+    renderOptions(values);
+  } catch (e) {
+    setStatus(`Cannot set the scan area: ${e.message}`);
+  }
+}
+
+function setupCrop() {
+  const stage = $("stage");
+  stage.onmousedown = (e) => {
+    if ($("preview-image").hidden) {
+      return;
+    }
+    dragStart = imagePoint(e);
+    e.preventDefault();
+  };
+  window.onmousemove = (e) => {
+    if (dragStart) {
+      drawCrop(dragStart, imagePoint(e));
+    }
+  };
+  window.onmouseup = (e) => {
+    if (!dragStart) {
+      return;
+    }
+    const a = dragStart;
+    const b = imagePoint(e);
+    dragStart = null;
+    const r = $("preview-image").getBoundingClientRect();
+    if (Math.abs(a.x - b.x) < 4 || Math.abs(a.y - b.y) < 4 || !previewArea) {
+      $("crop").hidden = true;
+      return;
+    }
+    const p = previewArea;
+    const sx = (p.brx - p.tlx) / r.width;
+    const sy = (p.bry - p.tly) / r.height;
+    setArea(
+      p.tlx + sx * Math.min(a.x, b.x), p.tly + sy * Math.min(a.y, b.y),
+      p.tlx + sx * Math.max(a.x, b.x), p.tly + sy * Math.max(a.y, b.y));
+  };
+}
+
+function init() {
+  $("device").onchange = () => {
+    device = $("device").value;
+    previewArea = null;
+    $("preview-image").hidden = true;
+    $("crop").hidden = true;
+    loadOptions().catch((e) => setStatus(e.message));
+  };
+  $("refresh").onclick = () => loadDevices().catch((e) => setStatus(e.message));
+  $("advanced").onchange = () => {
+    document.body.classList.toggle("show-advanced", $("advanced").checked);
+  };
+  $("preview").onclick = preview;
+  $("scan").onclick = () => scan(false);
+  $("batch").onclick = () => scan(true);
+  $("cancel").onclick = () => {
+    if (job) {
+      request("DELETE", jobURL(job.id)).catch((e) => setStatus(e.message));
+    }
+  };
+  $("clear-crop").onclick = () => {
+    $("crop").hidden = true;
+    if (previewArea) {
+      const p = previewArea;
+      setArea(p.tlx, p.tly, p.brx, p.bry);
+    }
+  };
+  setupCrop();
+  loadSinks().catch((e) => setStatus(e.message));
+  loadDevices().catch((e) => setStatus(e.message));
+}
+
+init();
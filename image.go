@@ -5,9 +5,11 @@
 package sane
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"sync"
 )
 
 var (
@@ -19,26 +21,34 @@ var (
 //
 // It implements the image.Image interface.
 type Image struct {
-	fs [3]*Frame // multiple frames must be in RGB order
+	XRes    float64   // horizontal resolution in dots per inch, 0 if unknown
+	YRes    float64   // vertical resolution in dots per inch, 0 if unknown
+	Area    Region    // scan area, zero if unknown
+	fs      [4]*Frame // multiple frames must be in RGB order, followed by infrared
+	encoded *Frame    // compressed frame, if any
+	once    sync.Once // guards decoding of the compressed frame
+	err     error     // error from decoding the compressed frame
 }
 
 // Bounds returns the domain for which At returns valid pixels.
 func (m *Image) Bounds() image.Rectangle {
-	f := m.fs[0]
+	fs := m.frames()
+	f := fs[0]
 	return image.Rect(0, 0, f.Width, f.Height)
 }
 
 // ColorModel returns the Image's color model.
 func (m *Image) ColorModel() color.Model {
-	f := m.fs[0]
+	fs := m.frames()
+	f := fs[0]
 	switch {
-	case f.Depth != 16 && f.Format == FrameGray:
+	case f.Depth <= 8 && f.isGray():
 		return color.GrayModel
-	case f.Depth == 16 && f.Format == FrameGray:
+	case f.Depth > 8 && f.isGray():
 		return color.Gray16Model
-	case f.Depth != 16 && f.Format != FrameGray:
+	case f.Depth <= 8 && !f.isGray():
 		return color.RGBAModel
-	case f.Depth == 16 && f.Format != FrameGray:
+	case f.Depth > 8 && !f.isGray():
 		return color.RGBA64Model
 	}
 	return color.RGBAModel
@@ -46,68 +56,220 @@ func (m *Image) ColorModel() color.Model {
 
 // At returns the color of the pixel at (x, y).
 func (m *Image) At(x, y int) color.Color {
-	if x < 0 || x >= m.fs[0].Width || y < 0 || y >= m.fs[0].Height {
+	fs := m.frames()
+	if x < 0 || x >= fs[0].Width || y < 0 || y >= fs[0].Height {
 		return color.RGBA{}
 	}
-	if m.fs[0].Format == FrameGray {
+	if fs[0].isGray() {
 		// grayscale
-		switch m.fs[0].Depth {
-		case 1:
-			return color.Gray{uint8(0xFF * m.fs[0].At(x, y, 0))}
-		case 8:
-			return color.Gray{uint8(m.fs[0].At(x, y, 0))}
-		case 16:
-			return color.Gray16{m.fs[0].At(x, y, 0)}
+		v := fs[0].at16(x, y, 0)
+		if fs[0].Depth <= 8 {
+			return color.Gray{uint8(v >> 8)}
 		}
+		return color.Gray16{v}
+	}
+	// color
+	var r, g, b uint16
+	if fs[0].Format == FrameRgb || fs[0].Format == FrameRgbi {
+		// interleaved
+		r = fs[0].at16(x, y, 0)
+		g = fs[0].at16(x, y, 1)
+		b = fs[0].at16(x, y, 2)
 	} else {
-		// color
-		var r, g, b uint16
-		if m.fs[0].Format == FrameRgb {
-			// interleaved
-			r = m.fs[0].At(x, y, 0)
-			g = m.fs[0].At(x, y, 1)
-			b = m.fs[0].At(x, y, 2)
-		} else {
-			// non-interleaved
-			r = m.fs[0].At(x, y, 0)
-			g = m.fs[1].At(x, y, 0)
-			b = m.fs[2].At(x, y, 0)
-		}
-		switch m.fs[0].Depth {
-		case 1:
-			return color.RGBA{uint8(0xFF * r), uint8(0xFF * g), uint8(0xFF * b), opaque8}
-		case 8:
-			return color.RGBA{uint8(r), uint8(g), uint8(b), opaque8}
-		case 16:
-			return color.RGBA64{r, g, b, opaque16}
-		}
-	}
-	return color.RGBA{} // shouldn't happen
+		// non-interleaved
+		r = fs[0].at16(x, y, 0)
+		g = fs[1].at16(x, y, 0)
+		b = fs[2].at16(x, y, 0)
+	}
+	if fs[0].Depth <= 8 {
+		return color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), opaque8}
+	}
+	return color.RGBA64{r, g, b, opaque16}
+}
+
+// Infrared returns the infrared channel of the image as an *image.Gray or
+// *image.Gray16, depending on the bit depth. It returns nil if the image has
+// no infrared data.
+func (m *Image) Infrared() image.Image {
+	fs := m.frames()
+	f, ch := fs[3], 0
+	switch {
+	case f != nil:
+	case fs[0].Format == FrameRgbi:
+		f, ch = fs[0], 3
+	case fs[0].Format == FrameGrayi:
+		f, ch = fs[0], 1
+	default:
+		return nil
+	}
+	r := image.Rect(0, 0, f.Width, f.Height)
+	s := make([]uint16, f.Width)
+	if f.Depth <= 8 {
+		d := image.NewGray(r)
+		for y := 0; y < f.Height; y++ {
+			f.row(s, 0, y, ch)
+			for x, v := range s {
+				d.Pix[y*d.Stride+x] = uint8(v >> 8)
+			}
+		}
+		return d
+	}
+	d := image.NewGray16(r)
+	for y := 0; y < f.Height; y++ {
+		f.row(s, 0, y, ch)
+		for x, v := range s {
+			d.Pix[y*d.Stride+2*x] = uint8(v >> 8)
+			d.Pix[y*d.Stride+2*x+1] = uint8(v)
+		}
+	}
+	return d
+}
+
+// resolution returns the current resolution in dots per inch, or zero if it
+// is unknown.
+func (c *Conn) resolution() (x, y float64) {
+	get := func(name string) float64 {
+		if o := c.findOption(name); o == nil || !o.IsActive || o.Unit != UnitDpi {
+			return 0
+		}
+		v, err := c.GetOption(name)
+		if err != nil {
+			return 0
+		}
+		f, _ := toFloat(v)
+		return f
+	}
+	x, y = get("x-resolution"), get("y-resolution")
+	if r := get("resolution"); r > 0 {
+		if x == 0 {
+			x = r
+		}
+		if y == 0 {
+			y = r
+		}
+	}
+	return x, y
 }
 
-// ReadImage reads an image from the connection.
+// add stores f in the slot of m corresponding to its format.
+func (m *Image) add(f *Frame) error {
+	switch f.Format {
+	case FrameGray, FrameRgb, FrameRed, FrameGrayi, FrameRgbi:
+		m.fs[0] = f
+	case FrameGreen:
+		m.fs[1] = f
+	case FrameBlue:
+		m.fs[2] = f
+	case FrameIr:
+		m.fs[3] = f
+	case FrameJpeg:
+		m.encoded = f // decoded on first use
+	default:
+		return &FormatError{f.Format, f.Depth}
+	}
+	return nil
+}
+
+// NewImage returns an image made up of the given frames, which must be
+// either a single gray or color frame, or separate red, green and blue
+// frames, optionally followed by an infrared frame. All frames must have the
+// same size and bit depth.
+func NewImage(fs ...*Frame) (*Image, error) {
+	m := Image{}
+	for _, f := range fs {
+		if err := m.add(f); err != nil {
+			return nil, err
+		}
+		if f.Width != fs[0].Width || f.Height != fs[0].Height || f.Depth != fs[0].Depth {
+			return nil, errors.New("sane: frames differ in size or bit depth")
+		}
+	}
+	switch {
+	case m.encoded != nil && len(fs) == 1:
+	case m.fs[0] == nil:
+		return nil, errors.New("sane: image has no visible frames")
+	case m.fs[0].Format == FrameRed && (m.fs[1] == nil || m.fs[2] == nil):
+		return nil, errors.New("sane: image is missing color frames")
+	case m.fs[0].Format != FrameRed && (m.fs[1] != nil || m.fs[2] != nil):
+		return nil, errors.New("sane: image has extra color frames")
+	}
+	return &m, nil
+}
+
+// ReadImage reads an image from the connection. The image's resolution and
+// scan area are taken from the current option values. Besides raw samples,
+// only JPEG data can be made into an image; frames holding other compressed
+// data, such as G3/G4 fax or text, must be read with ReadFrame instead.
 func (c *Conn) ReadImage() (*Image, error) {
 	defer c.Cancel()
+	return c.readImage(c.ReadFrame)
+}
 
+// readImage reads an image, obtaining its first frame by calling first.
+func (c *Conn) readImage(first func() (*Frame, error)) (*Image, error) {
 	m := Image{}
-	for {
-		f, err := c.ReadFrame()
+	read := first
+	for c.frame = 0; ; c.frame++ {
+		f, err := read()
 		if err != nil {
 			return nil, err
 		}
-		switch f.Format {
-		case FrameGray, FrameRgb, FrameRed:
-			m.fs[0] = f
-		case FrameGreen:
-			m.fs[1] = f
-		case FrameBlue:
-			m.fs[2] = f
-		default:
-			return nil, fmt.Errorf("unknown frame type %d", f.Format)
+		read = c.ReadFrame
+		if f.Encoded != nil && f.Format != FrameJpeg {
+			return nil, fmt.Errorf("sane: cannot make an image of %s data; use ReadFrame", f.MimeType)
+		}
+		if err := m.add(f); err != nil {
+			return nil, err
 		}
 		if f.IsLast {
 			break
 		}
 	}
+	if m.fs[0] == nil && m.encoded == nil {
+		return nil, errors.New("sane: image has no visible frames")
+	}
+	m.XRes, m.YRes = c.resolution()
+	if a, err := c.Region(); err == nil {
+		m.Area = a
+	}
+	c.page++
 	return &m, nil
 }
+
+// Rotate returns a copy of the image rotated clockwise by deg degrees, which
+// must be a multiple of 90. Unless deg is a multiple of 360, the scan area of
+// the copy is unknown, since its pixels no longer map onto the area in the
+// same orientation.
+func (m *Image) Rotate(deg int) (*Image, error) {
+	r := Image{XRes: m.XRes, YRes: m.YRes}
+	if deg%360 == 0 {
+		r.Area = m.Area
+	}
+	if deg%180 != 0 {
+		r.XRes, r.YRes = m.YRes, m.XRes
+	}
+	for i, f := range m.frames() {
+		if f == nil {
+			continue
+		}
+		g, err := f.Rotate(deg)
+		if err != nil {
+			return nil, err
+		}
+		r.fs[i] = g
+	}
+	return &r, nil
+}
+
+// Flip returns a mirrored copy of the image. If horizontal is true, the
+// left and right sides are swapped; otherwise, the top and bottom are. The
+// scan area of the copy is unknown, as for Rotate.
+func (m *Image) Flip(horizontal bool) *Image {
+	r := Image{XRes: m.XRes, YRes: m.YRes}
+	for i, f := range m.frames() {
+		if f != nil {
+			r.fs[i] = f.Flip(horizontal)
+		}
+	}
+	return &r
+}
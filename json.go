@@ -0,0 +1,383 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+var typeNames = map[Type]string{
+	TypeBool:   "bool",
+	TypeInt:    "int",
+	TypeFloat:  "float",
+	TypeString: "string",
+	TypeButton: "button",
+	typeGroup:  "group",
+}
+
+var unitNames = map[Unit]string{
+	UnitNone:    "none",
+	UnitPixel:   "pixel",
+	UnitBit:     "bit",
+	UnitMm:      "mm",
+	UnitDpi:     "dpi",
+	UnitPercent: "percent",
+	UnitUsec:    "microsecond",
+}
+
+var formatNames = map[Format]string{
+	FrameGray:  "gray",
+	FrameRgb:   "rgb",
+	FrameRed:   "red",
+	FrameGreen: "green",
+	FrameBlue:  "blue",
+	FrameText:  "text",
+	FrameJpeg:  "jpeg",
+	FrameG31D:  "g31d",
+	FrameG32D:  "g32d",
+	FrameG42D:  "g42d",
+	FrameIr:    "ir",
+	FrameRgbi:  "rgbi",
+	FrameGrayi: "grayi",
+	FrameXml:   "xml",
+}
+
+// enumString returns the name of an enum value, or a Go-like representation
+// if it has none.
+func enumString(kind string, v int, name string, ok bool) string {
+	if ok {
+		return name
+	}
+	return fmt.Sprintf("%s(%d)", kind, v)
+}
+
+// marshalEnum encodes an enum value as its name, or as a number if it has
+// none.
+func marshalEnum(v int, name string, ok bool) ([]byte, error) {
+	if ok {
+		return json.Marshal(name)
+	}
+	return json.Marshal(v)
+}
+
+// unmarshalEnum decodes an enum value encoded by marshalEnum, using lookup
+// to find the value with a given name.
+func unmarshalEnum(kind string, b []byte, lookup func(string) (int, bool)) (int, error) {
+	var n int
+	if err := json.Unmarshal(b, &n); err == nil {
+		return n, nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return 0, fmt.Errorf("sane: invalid %s: %s", kind, b)
+	}
+	n, ok := lookup(s)
+	if !ok {
+		return 0, fmt.Errorf("sane: unknown %s %q", kind, s)
+	}
+	return n, nil
+}
+
+func (t Type) String() string {
+	s, ok := typeNames[t]
+	return enumString("Type", int(t), s, ok)
+}
+
+// MarshalJSON encodes the type as a string, such as "int".
+func (t Type) MarshalJSON() ([]byte, error) {
+	s, ok := typeNames[t]
+	return marshalEnum(int(t), s, ok)
+}
+
+// UnmarshalJSON decodes a type encoded by MarshalJSON.
+func (t *Type) UnmarshalJSON(b []byte) error {
+	n, err := unmarshalEnum("type", b, func(s string) (int, bool) {
+		for v, name := range typeNames {
+			if name == s {
+				return int(v), true
+			}
+		}
+		return 0, false
+	})
+	*t = Type(n)
+	return err
+}
+
+func (u Unit) String() string {
+	s, ok := unitNames[u]
+	return enumString("Unit", int(u), s, ok)
+}
+
+// MarshalJSON encodes the unit as a string, such as "dpi".
+func (u Unit) MarshalJSON() ([]byte, error) {
+	s, ok := unitNames[u]
+	return marshalEnum(int(u), s, ok)
+}
+
+// UnmarshalJSON decodes a unit encoded by MarshalJSON.
+func (u *Unit) UnmarshalJSON(b []byte) error {
+	n, err := unmarshalEnum("unit", b, func(s string) (int, bool) {
+		for v, name := range unitNames {
+			if name == s {
+				return int(v), true
+			}
+		}
+		return 0, false
+	})
+	*u = Unit(n)
+	return err
+}
+
+func (f Format) String() string {
+	s, ok := formatNames[f]
+	return enumString("Format", int(f), s, ok)
+}
+
+// MarshalJSON encodes the format as a string, such as "rgb".
+func (f Format) MarshalJSON() ([]byte, error) {
+	s, ok := formatNames[f]
+	return marshalEnum(int(f), s, ok)
+}
+
+// UnmarshalJSON decodes a format encoded by MarshalJSON.
+func (f *Format) UnmarshalJSON(b []byte) error {
+	n, err := unmarshalEnum("format", b, func(s string) (int, bool) {
+		for v, name := range formatNames {
+			if name == s {
+				return int(v), true
+			}
+		}
+		return 0, false
+	})
+	*f = Format(n)
+	return err
+}
+
+// typed converts a value decoded from JSON to the Go type used for option
+// values of type t.
+func typed(t Type, v interface{}) interface{} {
+	f, ok := v.(float64)
+	if !ok {
+		return v
+	}
+	if t == TypeInt || (t != TypeFloat && f == math.Trunc(f)) {
+		return int(f)
+	}
+	return f
+}
+
+type rangeJSON struct {
+	Min   interface{} `json:"min"`
+	Max   interface{} `json:"max"`
+	Quant interface{} `json:"quant"`
+}
+
+// MarshalJSON encodes the range as an object with min, max and quant fields.
+func (r Range) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rangeJSON{r.Min, r.Max, r.Quant})
+}
+
+// UnmarshalJSON decodes a range encoded by MarshalJSON. Since the encoding
+// does not record the type of the values, they are decoded as int if they
+// are all integers, and as float64 otherwise; Option.UnmarshalJSON corrects
+// this according to the option type.
+func (r *Range) UnmarshalJSON(b []byte) error {
+	var j rangeJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	t := Type(TypeInt)
+	for _, v := range []interface{}{j.Min, j.Max, j.Quant} {
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("sane: invalid range: %s", b)
+		}
+		if f := v.(float64); f != math.Trunc(f) {
+			t = TypeFloat
+		}
+	}
+	r.Min, r.Max, r.Quant = typed(t, j.Min), typed(t, j.Max), typed(t, j.Quant)
+	return nil
+}
+
+type optionJSON struct {
+	Name            string        `json:"name"`
+	Group           string        `json:"group"`
+	Title           string        `json:"title"`
+	Desc            string        `json:"desc"`
+	Type            Type          `json:"type"`
+	Unit            Unit          `json:"unit"`
+	Length          int           `json:"length"`
+	ConstraintSet   []interface{} `json:"constraintSet,omitempty"`
+	ConstraintRange *Range        `json:"constraintRange,omitempty"`
+	Active          bool          `json:"active"`
+	Settable        bool          `json:"settable"`
+	Detectable      bool          `json:"detectable"`
+	Automatic       bool          `json:"automatic"`
+	Emulated        bool          `json:"emulated"`
+	Advanced        bool          `json:"advanced"`
+}
+
+// MarshalJSON encodes the option descriptor as an object with camel-case
+// field names, and with the type and unit as strings.
+func (o Option) MarshalJSON() ([]byte, error) {
+	return json.Marshal(optionJSON{
+		Name:            o.Name,
+		Group:           o.Group,
+		Title:           o.Title,
+		Desc:            o.Desc,
+		Type:            o.Type,
+		Unit:            o.Unit,
+		Length:          o.Length,
+		ConstraintSet:   o.ConstrSet,
+		ConstraintRange: o.ConstrRange,
+		Active:          o.IsActive,
+		Settable:        o.IsSettable,
+		Detectable:      o.IsDetectable,
+		Automatic:       o.IsAutomatic,
+		Emulated:        o.IsEmulated,
+		Advanced:        o.IsAdvanced,
+	})
+}
+
+// UnmarshalJSON decodes an option descriptor encoded by MarshalJSON. The
+// result can be inspected, but not used to get or set the option; use the
+// name to look the option up in Conn.Options instead.
+func (o *Option) UnmarshalJSON(b []byte) error {
+	var j optionJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*o = Option{
+		Name:         j.Name,
+		Group:        j.Group,
+		Title:        j.Title,
+		Desc:         j.Desc,
+		Type:         j.Type,
+		Unit:         j.Unit,
+		Length:       j.Length,
+		ConstrRange:  j.ConstraintRange,
+		IsActive:     j.Active,
+		IsSettable:   j.Settable,
+		IsDetectable: j.Detectable,
+		IsAutomatic:  j.Automatic,
+		IsEmulated:   j.Emulated,
+		IsAdvanced:   j.Advanced,
+	}
+	for _, v := range j.ConstraintSet {
+		o.ConstrSet = append(o.ConstrSet, typed(o.Type, v))
+	}
+	if r := o.ConstrRange; r != nil {
+		r.Min, r.Max, r.Quant = retype(o.Type, r.Min), retype(o.Type, r.Max), retype(o.Type, r.Quant)
+	}
+	return nil
+}
+
+// retype converts a range value decoded by Range.UnmarshalJSON to the type
+// used for option values of type t.
+func retype(t Type, v interface{}) interface{} {
+	switch v := v.(type) {
+	case int:
+		if t == TypeFloat {
+			return float64(v)
+		}
+	case float64:
+		if t == TypeInt {
+			return int(v)
+		}
+	}
+	return v
+}
+
+// MarshalJSON encodes the state as "unknown", "off" or "on".
+func (s SensorState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON decodes a state encoded by MarshalJSON.
+func (s *SensorState) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	for _, v := range []SensorState{SensorUnknown, SensorOff, SensorOn} {
+		if v.String() == str {
+			*s = v
+			return nil
+		}
+	}
+	return fmt.Errorf("sane: unknown sensor state %q", str)
+}
+
+type statusJSON struct {
+	PaperLoaded SensorState            `json:"paperLoaded"`
+	CoverOpen   SensorState            `json:"coverOpen"`
+	LampOn      SensorState            `json:"lampOn"`
+	Jammed      SensorState            `json:"jammed"`
+	Sensors     map[string]interface{} `json:"sensors"`
+}
+
+// MarshalJSON encodes the status as an object with camel-case field names.
+func (st Status) MarshalJSON() ([]byte, error) {
+	return json.Marshal(statusJSON(st))
+}
+
+// UnmarshalJSON decodes a status encoded by MarshalJSON.
+func (st *Status) UnmarshalJSON(b []byte) error {
+	var j statusJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*st = Status(j)
+	return nil
+}
+
+type deviceJSON struct {
+	Name   string `json:"name"`
+	Vendor string `json:"vendor"`
+	Model  string `json:"model"`
+	Type   string `json:"type"`
+}
+
+// MarshalJSON encodes the device as an object with lower-case field names.
+func (d Device) MarshalJSON() ([]byte, error) {
+	return json.Marshal(deviceJSON(d))
+}
+
+// UnmarshalJSON decodes a device encoded by MarshalJSON.
+func (d *Device) UnmarshalJSON(b []byte) error {
+	var j deviceJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*d = Device(j)
+	return nil
+}
+
+type paramsJSON struct {
+	Format        Format `json:"format"`
+	IsLast        bool   `json:"last"`
+	BytesPerLine  int    `json:"bytesPerLine"`
+	PixelsPerLine int    `json:"pixelsPerLine"`
+	Lines         int    `json:"lines"`
+	Depth         int    `json:"depth"`
+}
+
+// MarshalJSON encodes the parameters as an object with camel-case field
+// names, and with the format as a string.
+func (p Params) MarshalJSON() ([]byte, error) {
+	return json.Marshal(paramsJSON(p))
+}
+
+// UnmarshalJSON decodes parameters encoded by MarshalJSON.
+func (p *Params) UnmarshalJSON(b []byte) error {
+	var j paramsJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*p = Params(j)
+	return nil
+}
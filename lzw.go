@@ -0,0 +1,81 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+// LZW compression as used in TIFF files. It differs from compress/lzw in
+// that the code width increases one code earlier, as described in the TIFF
+// 6.0 specification.
+
+const (
+	lzwClear    = 256
+	lzwEOI      = 257
+	lzwFirst    = 258
+	lzwMaxWidth = 12
+)
+
+// lzwWriter accumulates codes, most significant bit first.
+type lzwWriter struct {
+	out  []byte
+	acc  uint32 // pending bits, in the low nacc bits
+	nacc uint
+}
+
+func (w *lzwWriter) code(c, width int) {
+	w.acc = w.acc<<uint(width) | uint32(c)
+	w.nacc += uint(width)
+	for w.nacc >= 8 {
+		w.out = append(w.out, byte(w.acc>>(w.nacc-8)))
+		w.nacc -= 8
+	}
+}
+
+func (w *lzwWriter) flush() []byte {
+	if w.nacc > 0 {
+		w.out = append(w.out, byte(w.acc<<(8-w.nacc)))
+		w.acc, w.nacc = 0, 0
+	}
+	return w.out
+}
+
+// encodeLZW compresses data with TIFF LZW.
+func encodeLZW(data []byte) []byte {
+	w := &lzwWriter{}
+	width, next := 9, lzwFirst
+	dict := make(map[int]int)
+	w.code(lzwClear, width)
+	prefix := -1
+	for _, b := range data {
+		if prefix < 0 {
+			prefix = int(b)
+			continue
+		}
+		key := prefix<<8 | int(b)
+		if c, ok := dict[key]; ok {
+			prefix = c
+			continue
+		}
+		w.code(prefix, width)
+		dict[key] = next
+		next++
+		if next == 1<<lzwMaxWidth-2 {
+			// Table full: start over.
+			w.code(lzwClear, width)
+			width, next = 9, lzwFirst
+			dict = make(map[int]int)
+		} else if next > 1<<uint(width)-1 {
+			width++
+		}
+		prefix = int(b)
+	}
+	if prefix >= 0 {
+		w.code(prefix, width)
+		// The decoder adds one more entry before reading the next code.
+		if next+1 > 1<<uint(width)-1 && width < lzwMaxWidth {
+			width++
+		}
+	}
+	w.code(lzwEOI, width)
+	return w.flush()
+}
@@ -0,0 +1,187 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"image"
+	"image/color"
+)
+
+// row fills s with the samples of channel ch in row y, starting at column x0,
+// scaled to the uint16 range.
+func (f *Frame) row(s []uint16, x0, y, ch int) {
+	switch f.Depth {
+	case 1:
+		// For B&W lineart, 0 is white and 1 is black
+		var inv uint8
+		if f.isGray() && ch == 0 {
+			inv = 0x01
+		}
+		for k := range s {
+			x := x0 + k
+			b := f.data[f.bytesPerLine*y+f.Channels*(x/8)+ch]
+			if (b>>uint8(x%8))&0x01^inv != 0 {
+				s[k] = 0xffff
+			} else {
+				s[k] = 0
+			}
+		}
+	case 8:
+		i := f.bytesPerLine*y + f.Channels*x0 + ch
+		for k := range s {
+			s[k] = uint16(f.data[i]) * 0x101
+			i += f.Channels
+		}
+	case 16:
+		i := f.bytesPerLine*y + 2*(f.Channels*x0+ch)
+		for k := range s {
+			s[k] = uint16(f.data[i+1])<<8 + uint16(f.data[i])
+			i += 2 * f.Channels
+		}
+	default:
+		for k := range s {
+			s[k] = f.at16(x0+k, y, ch)
+		}
+	}
+}
+
+// rows calls fn for each row in r, with the red, green and blue samples of
+// the row scaled to the uint16 range. For grayscale images, all three slices
+// are the same.
+func (m *Image) rows(r image.Rectangle, fn func(y int, red, green, blue []uint16)) {
+	fs := m.frames()
+	var s [3][]uint16
+	n := 1
+	if !fs[0].isGray() {
+		n = 3
+	}
+	for i := 0; i < n; i++ {
+		s[i] = make([]uint16, r.Dx())
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for i := 0; i < n; i++ {
+			if fs[0].Channels > 1 || fs[0].isGray() {
+				fs[0].row(s[i], r.Min.X, y, i)
+			} else {
+				fs[i].row(s[i], r.Min.X, y, 0)
+			}
+		}
+		if n == 1 {
+			fn(y, s[0], s[0], s[0])
+		} else {
+			fn(y, s[0], s[1], s[2])
+		}
+	}
+}
+
+// luma16 returns the luminance of an RGB color in the uint16 range, using
+// the same weights as color.GrayModel.
+func luma16(r, g, b uint16) uint32 {
+	return (19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16
+}
+
+func (m *Image) toGray(r image.Rectangle) *image.Gray {
+	d := image.NewGray(r)
+	m.rows(r, func(y int, red, green, blue []uint16) {
+		p := d.Pix[d.PixOffset(r.Min.X, y):]
+		for k := range red {
+			p[k] = uint8(luma16(red[k], green[k], blue[k]) >> 8)
+		}
+	})
+	return d
+}
+
+func (m *Image) toGray16(r image.Rectangle) *image.Gray16 {
+	d := image.NewGray16(r)
+	m.rows(r, func(y int, red, green, blue []uint16) {
+		p := d.Pix[d.PixOffset(r.Min.X, y):]
+		for k := range red {
+			v := luma16(red[k], green[k], blue[k])
+			p[2*k] = uint8(v >> 8)
+			p[2*k+1] = uint8(v)
+		}
+	})
+	return d
+}
+
+func (m *Image) toRGBA(r image.Rectangle) *image.RGBA {
+	d := image.NewRGBA(r)
+	m.rows(r, func(y int, red, green, blue []uint16) {
+		p := d.Pix[d.PixOffset(r.Min.X, y):]
+		for k := range red {
+			p[4*k] = uint8(red[k] >> 8)
+			p[4*k+1] = uint8(green[k] >> 8)
+			p[4*k+2] = uint8(blue[k] >> 8)
+			p[4*k+3] = opaque8
+		}
+	})
+	return d
+}
+
+func (m *Image) toRGBA64(r image.Rectangle) *image.RGBA64 {
+	d := image.NewRGBA64(r)
+	m.rows(r, func(y int, red, green, blue []uint16) {
+		p := d.Pix[d.PixOffset(r.Min.X, y):]
+		for k := range red {
+			p[8*k], p[8*k+1] = uint8(red[k]>>8), uint8(red[k])
+			p[8*k+2], p[8*k+3] = uint8(green[k]>>8), uint8(green[k])
+			p[8*k+4], p[8*k+5] = uint8(blue[k]>>8), uint8(blue[k])
+			p[8*k+6], p[8*k+7] = 0xff, 0xff
+		}
+	})
+	return d
+}
+
+// ToGray converts the image to an *image.Gray.
+func (m *Image) ToGray() *image.Gray {
+	return m.toGray(m.Bounds())
+}
+
+// ToGray16 converts the image to an *image.Gray16.
+func (m *Image) ToGray16() *image.Gray16 {
+	return m.toGray16(m.Bounds())
+}
+
+// ToRGBA converts the image to an *image.RGBA.
+func (m *Image) ToRGBA() *image.RGBA {
+	return m.toRGBA(m.Bounds())
+}
+
+// ToRGBA64 converts the image to an *image.RGBA64.
+func (m *Image) ToRGBA64() *image.RGBA64 {
+	return m.toRGBA64(m.Bounds())
+}
+
+func (m *Image) native(r image.Rectangle) image.Image {
+	switch m.ColorModel() {
+	case color.GrayModel:
+		return m.toGray(r)
+	case color.Gray16Model:
+		return m.toGray16(r)
+	case color.RGBA64Model:
+		return m.toRGBA64(r)
+	}
+	return m.toRGBA(r)
+}
+
+// Native converts the image to the standard library type matching its color
+// model: *image.Gray, *image.Gray16, *image.RGBA or *image.RGBA64. Pixel
+// access on the result is much faster, which benefits the image encoders.
+func (m *Image) Native() image.Image {
+	return m.native(m.Bounds())
+}
+
+// SubImage returns an image representing the portion of the image visible
+// through r. Unlike the standard library types, the result does not share
+// pixels with the original; it is converted as by Native.
+func (m *Image) SubImage(r image.Rectangle) image.Image {
+	return m.native(r.Intersect(m.Bounds()))
+}
+
+// Opaque reports whether the image is fully opaque, which is always the
+// case for scanned images.
+func (m *Image) Opaque() bool {
+	return true
+}
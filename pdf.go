@@ -0,0 +1,196 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+)
+
+// pdfWriter writes the objects of a PDF file, keeping track of their offsets
+// for the cross-reference table.
+type pdfWriter struct {
+	w       *bufio.Writer
+	n       int   // bytes written so far
+	offsets []int // offset of each object, indexed by object number - 1
+	err     error
+}
+
+func (p *pdfWriter) printf(format string, v ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, v...)
+	p.n += n
+	p.err = err
+}
+
+func (p *pdfWriter) write(b []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(b)
+	p.n += n
+	p.err = err
+}
+
+// object writes object number id, which must be the next one.
+func (p *pdfWriter) object(id int, dict string, stream []byte) {
+	p.offsets = append(p.offsets, p.n)
+	p.printf("%d 0 obj\n%s\n", id, dict)
+	if stream != nil {
+		p.printf("stream\n")
+		p.write(stream)
+		p.printf("\nendstream\n")
+	}
+	p.printf("endobj\n")
+}
+
+// pdfImage returns the dictionary entries and data of an image XObject.
+func pdfImage(m *Image) (string, []byte, error) {
+	if data, mt := m.Encoded(); mt == "image/jpeg" {
+		cs := "/DeviceRGB"
+		if m.encoded.Channels == 1 {
+			cs = "/DeviceGray"
+		}
+		return fmt.Sprintf("/ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode", cs),
+			data, nil
+	}
+
+	var (
+		raw []byte
+		cs  string
+		bpc int
+		b   = m.Bounds()
+	)
+	switch n := m.Native().(type) {
+	case *image.Gray:
+		cs, bpc = "/DeviceGray", 8
+		if m.frames()[0].Depth == 1 {
+			bpc, raw = 1, packBits(n)
+		} else {
+			raw = n.Pix
+		}
+	case *image.Gray16:
+		cs, bpc, raw = "/DeviceGray", 16, n.Pix
+	case *image.RGBA:
+		cs, bpc = "/DeviceRGB", 8
+		raw = make([]byte, 0, 3*b.Dx()*b.Dy())
+		for i := 0; i < len(n.Pix); i += 4 {
+			raw = append(raw, n.Pix[i:i+3]...)
+		}
+	case *image.RGBA64:
+		cs, bpc = "/DeviceRGB", 16
+		raw = make([]byte, 0, 6*b.Dx()*b.Dy())
+		for i := 0; i < len(n.Pix); i += 8 {
+			raw = append(raw, n.Pix[i:i+6]...)
+		}
+	}
+
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	if _, err := zw.Write(raw); err != nil {
+		return "", nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("/ColorSpace %s /BitsPerComponent %d /Filter /FlateDecode", cs, bpc),
+		z.Bytes(), nil
+}
+
+// packBits packs a bilevel grayscale image into rows of one bit per pixel,
+// most significant bit first, where 1 is white.
+func packBits(m *image.Gray) []byte {
+	b := m.Bounds()
+	bpl := (b.Dx() + 7) / 8
+	out := make([]byte, bpl*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		row := m.Pix[y*m.Stride : y*m.Stride+b.Dx()]
+		for x, v := range row {
+			if v >= 0x80 {
+				out[y*bpl+x/8] |= 0x80 >> uint(x%8)
+			}
+		}
+	}
+	return out
+}
+
+// EncodePDF writes the images to w as a PDF document, one image per page.
+// Images received from the device as JPEG data are embedded without being
+// recompressed; others are compressed losslessly.
+func EncodePDF(w io.Writer, ms []*Image) error {
+	p := &pdfWriter{w: bufio.NewWriter(w)}
+	p.printf("%%PDF-1.5\n%%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 and 2 are the catalog and the page tree; each page takes
+	// three more objects: the page itself, its contents and its image.
+	kids := new(bytes.Buffer)
+	for i := range ms {
+		fmt.Fprintf(kids, "%d 0 R ", 3+3*i)
+	}
+	p.object(1, "<< /Type /Catalog /Pages 2 0 R >>", nil)
+	p.object(2, fmt.Sprintf("<< /Type /Pages /Kids [ %s] /Count %d >>",
+		kids, len(ms)), nil)
+
+	for i, m := range ms {
+		id := 3 + 3*i
+		w, h := pixelSize(m)
+		pw, ph := pageSize(m)
+		p.object(id, fmt.Sprintf("<< /Type /Page /Parent 2 0 R "+
+			"/MediaBox [0 0 %.2f %.2f] /Contents %d 0 R "+
+			"/Resources << /XObject << /Im0 %d 0 R >> >> >>",
+			pw, ph, id+1, id+2), nil)
+		content := []byte(fmt.Sprintf("q %.2f 0 0 %.2f 0 0 cm /Im0 Do Q", pw, ph))
+		p.object(id+1, fmt.Sprintf("<< /Length %d >>", len(content)), content)
+		dict, data, err := pdfImage(m)
+		if err != nil {
+			return err
+		}
+		p.object(id+2, fmt.Sprintf("<< /Type /XObject /Subtype /Image "+
+			"/Width %d /Height %d %s /Length %d >>",
+			w, h, dict, len(data)), data)
+	}
+
+	xref := p.n
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", len(p.offsets)+1)
+	for _, off := range p.offsets {
+		p.printf("%010d 00000 n \n", off)
+	}
+	p.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(p.offsets)+1, xref)
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
+
+// pageSize returns the size of the page holding m, in points.
+func pageSize(m *Image) (float64, float64) {
+	// Without resolution information, assume one pixel per point.
+	xres, yres := m.XRes, m.YRes
+	if xres <= 0 {
+		xres = 72
+	}
+	if yres <= 0 {
+		yres = 72
+	}
+	w, h := pixelSize(m)
+	return float64(w) * 72 / xres, float64(h) * 72 / yres
+}
+
+// pixelSize returns the size of m in pixels. Images received as JPEG data are
+// not decoded, since their data is embedded as is.
+func pixelSize(m *Image) (int, int) {
+	if e := m.encoded; e != nil {
+		return e.Width, e.Height
+	}
+	b := m.Bounds()
+	return b.Dx(), b.Dy()
+}
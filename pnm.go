@@ -0,0 +1,194 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// A pnmSource is a channel of a frame that makes up a channel of the output.
+type pnmSource struct {
+	f  *Frame
+	ch int
+}
+
+// pnmSources returns the frame channels making up the channels of the image,
+// in output order, along with the PAM tuple type.
+func pnmSources(fs *[4]*Frame) ([]pnmSource, string) {
+	f := fs[0]
+	var srcs []pnmSource
+	tuple := "RGB"
+	switch f.Format {
+	case FrameGray, FrameGrayi:
+		tuple = "GRAYSCALE"
+		if f.Depth == 1 {
+			tuple = "BLACKANDWHITE"
+		}
+		fallthrough
+	case FrameRgb, FrameRgbi:
+		for ch := 0; ch < f.Channels; ch++ {
+			srcs = append(srcs, pnmSource{f, ch})
+		}
+	default:
+		for _, f := range fs[:3] {
+			srcs = append(srcs, pnmSource{f, 0})
+		}
+	}
+	if fs[3] != nil {
+		srcs = append(srcs, pnmSource{fs[3], 0})
+	}
+	if len(srcs) == 2 || len(srcs) == 4 {
+		tuple += "_INFRARED"
+	}
+	return srcs, tuple
+}
+
+// pnmHeader returns the header for an image of the given size and channels.
+// It uses the PBM, PGM or PPM formats when possible, and PAM otherwise.
+func pnmHeader(width, height, depth int, nch int, tuple string) string {
+	maxval := 1<<uint(depth) - 1
+	switch {
+	case nch == 1 && depth == 1:
+		return fmt.Sprintf("P4\n%d %d\n", width, height)
+	case nch == 1:
+		return fmt.Sprintf("P5\n%d %d\n%d\n", width, height, maxval)
+	case nch == 3:
+		return fmt.Sprintf("P6\n%d %d\n%d\n", width, height, maxval)
+	}
+	return fmt.Sprintf("P7\nWIDTH %d\nHEIGHT %d\nDEPTH %d\nMAXVAL %d\nTUPLTYPE %s\nENDHDR\n",
+		width, height, nch, maxval, tuple)
+}
+
+// pnmRow appends row y of the image to b, in PNM order.
+func pnmRow(b []byte, srcs []pnmSource, y int) []byte {
+	f := srcs[0].f
+	width := f.Width
+	switch {
+	case len(srcs) == 1 && f.Depth == 1:
+		// PBM: most significant bit first, 1 is black.
+		raw := f.data[y*f.bytesPerLine:]
+		n := (width + 7) / 8
+		for i := 0; i < n; i++ {
+			b = append(b, bitReverse[raw[i*f.Channels]])
+		}
+		if width%8 != 0 {
+			b[len(b)-1] &= 0xff << uint(8-width%8)
+		}
+		return b
+	case len(srcs) == f.Channels && f.Depth == 8:
+		// The raw data is already in the right order.
+		return append(b, f.data[y*f.bytesPerLine:y*f.bytesPerLine+width*f.Channels]...)
+	case len(srcs) == f.Channels && f.Depth == 16:
+		// The raw data is little-endian.
+		raw := f.data[y*f.bytesPerLine : y*f.bytesPerLine+2*width*f.Channels]
+		for i := 0; i < len(raw); i += 2 {
+			b = append(b, raw[i+1], raw[i])
+		}
+		return b
+	}
+	for x := 0; x < width; x++ {
+		for _, s := range srcs {
+			v := s.f.At(x, y, s.ch)
+			if s.f.Depth > 8 {
+				b = append(b, byte(v>>8))
+			}
+			b = append(b, byte(v))
+		}
+	}
+	return b
+}
+
+// WritePNM writes the image to w in the format used by scanimage: PBM for
+// lineart, PGM for grayscale and PPM for color, with big-endian samples at
+// depths above 8. Images with an infrared channel are written in PAM format.
+func (m *Image) WritePNM(w io.Writer) error {
+	fs := m.frames()
+	srcs, tuple := pnmSources(fs)
+	f := fs[0]
+	bw := bufio.NewWriter(w)
+	if _, err := io.WriteString(bw, pnmHeader(f.Width, f.Height, f.Depth, len(srcs), tuple)); err != nil {
+		return err
+	}
+	var row []byte
+	for y := 0; y < f.Height; y++ {
+		row = pnmRow(row[:0], srcs, y)
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// StreamPNM scans an image and writes it to w in the same format as
+// Image.WritePNM, converting each line as soon as it is read. This requires
+// a single-frame image of known height; otherwise, the whole image is read
+// before being written. If the device returns fewer lines than it announced,
+// the image is padded with zero samples.
+func (c *Conn) StreamPNM(w io.Writer) error {
+	defer c.Cancel()
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+	p, err := c.Params()
+	if err != nil {
+		return err
+	}
+	if !p.IsLast || p.Lines <= 0 || channels(p.Format) == 0 ||
+		p.Format == FrameIr || !supportedDepth(p.Depth) {
+		m, err := c.readImage(func() (*Frame, error) { return c.readFrame(p) })
+		if err != nil {
+			return err
+		}
+		return m.WritePNM(w)
+	}
+
+	c.frame = 0
+	line := &Frame{
+		Format:       p.Format,
+		Width:        p.PixelsPerLine,
+		Height:       1,
+		Channels:     channels(p.Format),
+		Depth:        p.Depth,
+		IsLast:       true,
+		bytesPerLine: p.BytesPerLine,
+		data:         make([]byte, p.BytesPerLine)}
+	fs := [4]*Frame{line}
+	srcs, tuple := pnmSources(&fs)
+
+	bw := bufio.NewWriter(w)
+	if _, err := io.WriteString(bw, pnmHeader(line.Width, p.Lines, p.Depth, len(srcs), tuple)); err != nil {
+		return err
+	}
+	r := newProgressReader(c, p)
+	var row []byte
+	eof := false
+	for y := 0; y < p.Lines; y++ {
+		if !eof {
+			_, err := io.ReadFull(r, line.data)
+			switch err {
+			case nil:
+			case io.EOF, io.ErrUnexpectedEOF:
+				eof = true
+				for i := range line.data {
+					line.data[i] = 0
+				}
+			default:
+				return err
+			}
+		}
+		row = pnmRow(row[:0], srcs, 0)
+		if _, err := bw.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	c.page++
+	return nil
+}
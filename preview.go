@@ -0,0 +1,258 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Resolution used for preview scans, in dots per inch. The closest value
+// allowed by the device is used.
+const previewDpi = 75
+
+// Names of the well-known geometry options, in the order they are set.
+var geometryOpts = []string{"tl-x", "tl-y", "br-x", "br-y"}
+
+// A Region is a scan area, expressed as values for the tl-x, tl-y, br-x and
+// br-y options.
+type Region struct {
+	TLX, TLY float64 // top-left corner
+	BRX, BRY float64 // bottom-right corner
+	Unit     Unit    // unit of the coordinates, usually UnitMm or UnitPixel
+}
+
+// Preview is a low-resolution scan of the whole scan area.
+type Preview struct {
+	*Image        // scanned image
+	Area   Region // scan area covered by the image
+}
+
+// Region converts a rectangle in preview pixel coordinates into the
+// corresponding scan area. It returns the zero Region if the preview image is
+// empty.
+func (p *Preview) Region(r image.Rectangle) Region {
+	b := p.Bounds()
+	if b.Empty() {
+		return Region{}
+	}
+	r = r.Intersect(b)
+	sx := (p.Area.BRX - p.Area.TLX) / float64(b.Dx())
+	sy := (p.Area.BRY - p.Area.TLY) / float64(b.Dy())
+	return Region{
+		TLX:  p.Area.TLX + sx*float64(r.Min.X-b.Min.X),
+		TLY:  p.Area.TLY + sy*float64(r.Min.Y-b.Min.Y),
+		BRX:  p.Area.TLX + sx*float64(r.Max.X-b.Min.X),
+		BRY:  p.Area.TLY + sy*float64(r.Max.Y-b.Min.Y),
+		Unit: p.Area.Unit}
+}
+
+// toFloat converts an int or float64 option value to float64.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// fromFloat converts a float64 to a value of the appropriate type for o.
+func fromFloat(o *Option, f float64) interface{} {
+	if o.Type == TypeInt {
+		return int(math.Floor(f + 0.5))
+	}
+	return f
+}
+
+// findOption returns the named option, or nil if there is none.
+func (c *Conn) findOption(name string) *Option {
+	opts := c.Options()
+	if i, ok := c.byName[name]; ok {
+		return &opts[i]
+	}
+	return nil
+}
+
+// settable returns the named option if it exists and can be set.
+func (c *Conn) settable(name string) *Option {
+	o := c.findOption(name)
+	if o == nil || !o.IsActive || !o.IsSettable {
+		return nil
+	}
+	return o
+}
+
+// bounds returns the smallest and largest values allowed for o.
+func bounds(o *Option) (min, max float64, ok bool) {
+	if o.ConstrRange != nil {
+		min, _ = toFloat(o.ConstrRange.Min)
+		max, _ = toFloat(o.ConstrRange.Max)
+		return min, max, true
+	}
+	for i, v := range o.ConstrSet {
+		f, _ := toFloat(v)
+		if i == 0 || f < min {
+			min = f
+		}
+		if i == 0 || f > max {
+			max = f
+		}
+	}
+	return min, max, len(o.ConstrSet) > 0
+}
+
+// closest returns the value allowed for o that is closest to f.
+func closest(o *Option, f float64) float64 {
+	if o.ConstrRange != nil {
+		min, _ := toFloat(o.ConstrRange.Min)
+		max, _ := toFloat(o.ConstrRange.Max)
+		q, _ := toFloat(o.ConstrRange.Quant)
+		if q > 0 {
+			f = min + q*math.Floor((f-min)/q+0.5)
+		}
+		return math.Max(min, math.Min(max, f))
+	}
+	best := f
+	for i, v := range o.ConstrSet {
+		g, _ := toFloat(v)
+		if i == 0 || math.Abs(g-f) < math.Abs(best-f) {
+			best = g
+		}
+	}
+	return best
+}
+
+// Region returns the current scan area.
+func (c *Conn) Region() (Region, error) {
+	var (
+		r Region
+		p = []*float64{&r.TLX, &r.TLY, &r.BRX, &r.BRY}
+	)
+	for i, name := range geometryOpts {
+		o := c.findOption(name)
+		if o == nil {
+			return r, fmt.Errorf("no option named %s", name)
+		}
+		v, err := c.GetOption(name)
+		if err != nil {
+			return r, err
+		}
+		*p[i], _ = toFloat(v)
+		r.Unit = o.Unit
+	}
+	return r, nil
+}
+
+// SetRegion sets the scan area.
+func (c *Conn) SetRegion(r Region) error {
+	for i, f := range []float64{r.TLX, r.TLY, r.BRX, r.BRY} {
+		name := geometryOpts[i]
+		o := c.settable(name)
+		if o == nil {
+			return fmt.Errorf("option %s cannot be set", name)
+		}
+		if o.Unit != r.Unit {
+			return fmt.Errorf("option %s is not in the region's unit", name)
+		}
+		if _, err := c.SetOption(name, fromFloat(o, f)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readImageContext is like ReadImage, but cancels the scan if ctx is done.
+func (c *Conn) readImageContext(ctx context.Context) (*Image, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Cancel()
+		case <-done:
+		}
+	}()
+	m, err := c.ReadImage()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return m, err
+}
+
+// Preview scans the whole scan area at low resolution. It temporarily sets
+// the preview option, the resolution and the scan area; their previous values
+// are restored before returning. The scan is cancelled if ctx is done.
+func (c *Conn) Preview(ctx context.Context) (p *Preview, err error) {
+	type saved struct {
+		name string
+		v    interface{}
+	}
+	var restore []saved
+	defer func() {
+		// Restore in reverse order, since later options may depend on
+		// earlier ones.
+		for i := len(restore) - 1; i >= 0; i-- {
+			if _, rerr := c.SetOption(restore[i].name, restore[i].v); err == nil {
+				err = rerr
+			}
+		}
+		if err != nil {
+			p = nil
+		}
+	}()
+	set := func(name string, v interface{}) error {
+		old, err := c.GetOption(name)
+		if err != nil {
+			return err
+		}
+		if _, err := c.SetOption(name, v); err != nil {
+			return err
+		}
+		restore = append(restore, saved{name, old})
+		return nil
+	}
+
+	if o := c.settable("preview"); o != nil && o.Type == TypeBool {
+		if err := set(o.Name, true); err != nil {
+			return nil, err
+		}
+	}
+	if o := c.settable("resolution"); o != nil {
+		if err := set(o.Name, fromFloat(o, closest(o, previewDpi))); err != nil {
+			return nil, err
+		}
+	}
+	for i, name := range geometryOpts {
+		o := c.settable(name)
+		if o == nil {
+			continue
+		}
+		min, max, ok := bounds(o)
+		if !ok {
+			continue
+		}
+		v := min // top-left corner
+		if i >= 2 {
+			v = max // bottom-right corner
+		}
+		if err := set(name, fromFloat(o, v)); err != nil {
+			return nil, err
+		}
+	}
+
+	area, err := c.Region()
+	if err != nil {
+		return nil, err
+	}
+	m, err := c.readImageContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Preview{m, area}, nil
+}
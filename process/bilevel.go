@@ -0,0 +1,205 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"github.com/tjgq/sane"
+	"math"
+)
+
+// The functions in this file convert images to 1-bit lineart, as produced by
+// the lineart mode of most scanners. Color images are converted to grayscale
+// first, and infrared data is dropped.
+
+// bilevel returns a 1-bit image of the same size as m, where the pixel at
+// (x,y) is white if white(x, y, v) is true, v being the 8-bit luminance of
+// the pixel in m. The pixels are visited row by row, from the top left.
+func bilevel(m *sane.Image, white func(x, y int, v uint8) bool) *sane.Image {
+	g := m.ToGray()
+	r, f := newImage(m, sane.FrameGray, 1)
+	b := g.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		row := g.Pix[y*g.Stride:]
+		for x := 0; x < b.Dx(); x++ {
+			var v uint16
+			if white(x, y, row[x]) {
+				v = 1
+			}
+			f.Set(x, y, 0, v)
+		}
+	}
+	return r
+}
+
+// Threshold converts m to lineart, making a pixel white if its luminance is
+// greater than level, from 0 to 1.
+func Threshold(m *sane.Image, level float64) *sane.Image {
+	t := level * 255
+	return bilevel(m, func(x, y int, v uint8) bool { return float64(v) > t })
+}
+
+// OtsuLevel returns the threshold level for m chosen by Otsu's method, which
+// best separates the pixels into two classes, such as ink and paper.
+func OtsuLevel(m *sane.Image) float64 {
+	g := m.ToGray()
+	var hist [256]float64
+	b := g.Bounds()
+	for y := 0; y < b.Dy(); y++ {
+		for _, v := range g.Pix[y*g.Stride : y*g.Stride+b.Dx()] {
+			hist[v]++
+		}
+	}
+	total, sum := 0.0, 0.0
+	for v, n := range hist {
+		total += n
+		sum += float64(v) * n
+	}
+
+	// Maximize the variance between the pixels at or below the threshold
+	// and those above it.
+	best, bestVar := 0, -1.0
+	n0, sum0 := 0.0, 0.0
+	for t := 0; t < 255; t++ {
+		n0 += hist[t]
+		sum0 += float64(t) * hist[t]
+		n1 := total - n0
+		if n0 == 0 || n1 == 0 {
+			continue
+		}
+		d := sum0/n0 - (sum-sum0)/n1
+		if v := n0 * n1 * d * d; v > bestVar {
+			best, bestVar = t, v
+		}
+	}
+	return float64(best) / 255
+}
+
+// Otsu converts m to lineart using the threshold level chosen by OtsuLevel.
+// It works well for evenly lit pages with good contrast.
+func Otsu(m *sane.Image) *sane.Image {
+	return Threshold(m, OtsuLevel(m))
+}
+
+// SauvolaOptions controls adaptive thresholding.
+type SauvolaOptions struct {
+	Window int     // side of the neighborhood considered for each pixel, in pixels
+	K      float64 // sensitivity to local contrast, usually from 0.2 to 0.5
+}
+
+// DefaultSauvolaOptions are reasonable settings for text scanned at 300 dpi.
+var DefaultSauvolaOptions = SauvolaOptions{
+	Window: 25,
+	K:      0.34,
+}
+
+// Sauvola converts m to lineart using Sauvola's adaptive method, where the
+// threshold for each pixel depends on the mean and standard deviation of the
+// luminance in its neighborhood. It copes with uneven lighting, stains and
+// tinted paper better than a global threshold. The window should be about
+// the height of a line of text.
+func Sauvola(m *sane.Image, opts SauvolaOptions) *sane.Image {
+	g := m.ToGray()
+	b := g.Bounds()
+	w, h := b.Dx(), b.Dy()
+	rad := opts.Window / 2
+
+	// Keep the sums of the samples and their squares in each column of the
+	// window, updating them as the window moves down.
+	colSum := make([]uint64, w)
+	colSq := make([]uint64, w)
+	addRow := func(y int, sign int) {
+		for x, v := range g.Pix[y*g.Stride : y*g.Stride+w] {
+			s := uint64(v)
+			if sign > 0 {
+				colSum[x] += s
+				colSq[x] += s * s
+			} else {
+				colSum[x] -= s
+				colSq[x] -= s * s
+			}
+		}
+	}
+	for y := 0; y < rad && y < h; y++ {
+		addRow(y, 1)
+	}
+	sum := make([]uint64, w+1)
+	sq := make([]uint64, w+1)
+	thr := make([]float64, w)
+
+	// setRow moves the window down to row y and computes its thresholds.
+	setRow := func(y int) {
+		if y+rad < h {
+			addRow(y+rad, 1)
+		}
+		if y-rad-1 >= 0 {
+			addRow(y-rad-1, -1)
+		}
+		rows := clamp(y+rad, 0, h-1) - clamp(y-rad, 0, h-1) + 1
+		for x := 0; x < w; x++ {
+			sum[x+1] = sum[x] + colSum[x]
+			sq[x+1] = sq[x] + colSq[x]
+		}
+		for x := 0; x < w; x++ {
+			x0, x1 := clamp(x-rad, 0, w-1), clamp(x+rad, 0, w-1)
+			n := float64(rows * (x1 - x0 + 1))
+			mean := float64(sum[x1+1]-sum[x0]) / n
+			variance := float64(sq[x1+1]-sq[x0])/n - mean*mean
+			std := math.Sqrt(math.Max(0, variance))
+			thr[x] = mean * (1 + opts.K*(std/128-1))
+		}
+	}
+	// bilevel visits the pixels row by row, so only the thresholds of the
+	// current row are kept.
+	y0 := -1
+	return bilevel(m, func(x, y int, v uint8) bool {
+		if y != y0 {
+			setRow(y)
+			y0 = y
+		}
+		return float64(v) > thr[x]
+	})
+}
+
+// clamp returns v limited to the range from lo to hi.
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// Dither converts m to lineart using Floyd–Steinberg error diffusion, which
+// preserves the tones of photographs and illustrations.
+func Dither(m *sane.Image) *sane.Image {
+	w := m.Bounds().Dx()
+	// Errors carried to the current and next rows, in 1/16ths, with a
+	// sample of padding on each side.
+	cur := make([]int, w+2)
+	next := make([]int, w+2)
+	y0 := -1
+	return bilevel(m, func(x, y int, v uint8) bool {
+		if y != y0 {
+			cur, next = next, cur
+			for i := range next {
+				next[i] = 0
+			}
+			y0 = y
+		}
+		s := int(v) + cur[x+1]/16
+		out := 0
+		if s >= 128 {
+			out = 255
+		}
+		e := s - out
+		cur[x+2] += 7 * e
+		next[x] += 3 * e
+		next[x+1] += 5 * e
+		next[x+2] += e
+		return out != 0
+	})
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"github.com/tjgq/sane"
+	"image"
+	"image/color"
+	"math"
+)
+
+// Number of rows converted at a time by samples.
+const stripHeight = 64
+
+// samples calls fn for each row of m, with the red, green and blue samples of
+// the row scaled to the uint16 range. For grayscale images, all three slices
+// are the same. Only a few rows are converted at a time, so that large scans
+// need not be held in memory twice.
+func samples(m *sane.Image, fn func(y int, r, g, b []uint16)) {
+	bd := m.Bounds()
+	w := bd.Dx()
+	s := [3][]uint16{make([]uint16, w), make([]uint16, w), make([]uint16, w)}
+	for y0 := bd.Min.Y; y0 < bd.Max.Y; y0 += stripHeight {
+		strip := m.SubImage(image.Rect(bd.Min.X, y0, bd.Max.X, y0+stripHeight))
+		sb := strip.Bounds()
+		for y := sb.Min.Y; y < sb.Max.Y; y++ {
+			switch p := strip.(type) {
+			case *image.Gray:
+				row := p.Pix[p.PixOffset(sb.Min.X, y):]
+				for k := range s[0] {
+					s[0][k] = uint16(row[k]) * 0x101
+				}
+				fn(y, s[0], s[0], s[0])
+			case *image.Gray16:
+				row := p.Pix[p.PixOffset(sb.Min.X, y):]
+				for k := range s[0] {
+					s[0][k] = uint16(row[2*k])<<8 | uint16(row[2*k+1])
+				}
+				fn(y, s[0], s[0], s[0])
+			case *image.RGBA:
+				row := p.Pix[p.PixOffset(sb.Min.X, y):]
+				for k := range s[0] {
+					for i := range s {
+						s[i][k] = uint16(row[4*k+i]) * 0x101
+					}
+				}
+				fn(y, s[0], s[1], s[2])
+			case *image.RGBA64:
+				row := p.Pix[p.PixOffset(sb.Min.X, y):]
+				for k := range s[0] {
+					for i := range s {
+						s[i][k] = uint16(row[8*k+2*i])<<8 | uint16(row[8*k+2*i+1])
+					}
+				}
+				fn(y, s[0], s[1], s[2])
+			}
+		}
+	}
+}
+
+// formatOf returns the single-frame format corresponding to m.
+func formatOf(m *sane.Image) sane.Format {
+	switch m.ColorModel() {
+	case color.GrayModel, color.Gray16Model:
+		return sane.FrameGray
+	}
+	return sane.FrameRgb
+}
+
+// outDepth returns the bit depth of the 8 or 16-bit image corresponding to m.
+func outDepth(m *sane.Image) int {
+	switch m.ColorModel() {
+	case color.Gray16Model, color.RGBA64Model:
+		return 16
+	}
+	return 8
+}
+
+// newImage returns a blank single-frame image of the given format and bit
+// depth, with the same size, resolution and scan area as m.
+func newImage(m *sane.Image, format sane.Format, depth int) (*sane.Image, *sane.Frame) {
+	b := m.Bounds()
+	f, err := sane.NewFrame(format, b.Dx(), b.Dy(), depth)
+	if err != nil {
+		panic(err) // format and depth are always valid
+	}
+	r, err := sane.NewImage(f)
+	if err != nil {
+		panic(err)
+	}
+	r.XRes, r.YRes, r.Area = m.XRes, m.YRes, m.Area
+	return r, f
+}
+
+// mapSamples returns a copy of m with the given format and bit depth, where
+// each sample is fn applied to the corresponding sample of m. The format must
+// be sane.FrameGray or sane.FrameRgb; in the former case, fn is applied to
+// the luminance of each pixel.
+func mapSamples(m *sane.Image, format sane.Format, depth int, fn func(v uint16) uint16) *sane.Image {
+	r, f := newImage(m, format, depth)
+	shift := uint(16 - depth)
+	samples(m, func(y int, red, green, blue []uint16) {
+		for x := range red {
+			if format == sane.FrameGray {
+				f.Set(x, y, 0, fn(luma16(red[x], green[x], blue[x]))>>shift)
+				continue
+			}
+			f.Set(x, y, 0, fn(red[x])>>shift)
+			f.Set(x, y, 1, fn(green[x])>>shift)
+			f.Set(x, y, 2, fn(blue[x])>>shift)
+		}
+	})
+	return r
+}
+
+// luma16 returns the luminance of an RGB color in the uint16 range, using
+// the same weights as color.GrayModel.
+func luma16(r, g, b uint16) uint16 {
+	return uint16((19595*uint32(r) + 38470*uint32(g) + 7471*uint32(b) + 1<<15) >> 16)
+}
+
+func identity(v uint16) uint16 {
+	return v
+}
+
+// Gray converts m to grayscale, keeping its bit depth. Images with more than
+// 8 bits per sample become 16-bit images; others become 8-bit images.
+// Infrared data is dropped.
+func Gray(m *sane.Image) *sane.Image {
+	return mapSamples(m, sane.FrameGray, outDepth(m), identity)
+}
+
+// Reduce converts m to 8 bits per sample, for use with encoders and viewers
+// that do not support higher depths. Infrared data is dropped.
+func Reduce(m *sane.Image) *sane.Image {
+	return mapSamples(m, formatOf(m), 8, func(v uint16) uint16 {
+		// Round to the nearest 8-bit value.
+		return uint16((uint32(v)*255+0x7fff)/0xffff) * 0x101
+	})
+}
+
+// Adjustment is a tone correction.
+type Adjustment struct {
+	Gamma      float64 // gamma correction; 1 or 0 leaves the image unchanged
+	Brightness float64 // amount added to each sample, from -1 to 1
+	Contrast   float64 // amount of contrast added around mid-gray, from -1 to 1
+}
+
+// table returns a lookup table mapping uint16 samples to adjusted samples.
+func (a Adjustment) table() []uint16 {
+	g := a.Gamma
+	if g <= 0 {
+		g = 1
+	}
+	t := make([]uint16, 0x10000)
+	for i := range t {
+		v := math.Pow(float64(i)/0xffff, 1/g)
+		v = (v-0.5)*(1+a.Contrast) + 0.5 + a.Brightness
+		t[i] = uint16(math.Max(0, math.Min(1, v))*0xffff + 0.5)
+	}
+	return t
+}
+
+// Adjust applies a tone correction to m, keeping its bit depth as in Gray.
+// The gamma correction is applied first, followed by the contrast and
+// brightness adjustments. Infrared data is dropped.
+func Adjust(m *sane.Image, a Adjustment) *sane.Image {
+	t := a.table()
+	return mapSamples(m, formatOf(m), outDepth(m), func(v uint16) uint16 { return t[v] })
+}
@@ -0,0 +1,85 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Smallest difference in luminance between paper and backing for the edges
+// of the paper to be detected.
+const minContrast = 0.1
+
+// PaperBounds detects the area of m covered by paper, by comparing the
+// luminance along the edges of the image, where the scanner backing shows,
+// to that of its center. It returns m.Bounds() if no backing is visible.
+func PaperBounds(m image.Image) image.Rectangle {
+	b := m.Bounds()
+	l := newLumaMap(m)
+	if l.w < 3 || l.h < 3 {
+		return b
+	}
+	paper, backing := l.median(l.center()), l.border()
+	if paper-backing < minContrast {
+		return b
+	}
+	thr := (paper + backing) / 2
+
+	// A row or column belongs to the paper if most of its samples do.
+	isPaper := func(n int, at func(i int) float64) bool {
+		k := 0
+		for i := 0; i < n; i++ {
+			if at(i) > thr {
+				k++
+			}
+		}
+		return 2*k > n
+	}
+	col := func(x int) bool {
+		return isPaper(l.h, func(y int) float64 { return l.at(x, y) })
+	}
+	row := func(y int) bool {
+		return isPaper(l.w, func(x int) float64 { return l.at(x, y) })
+	}
+
+	x0, x1, y0, y1 := 0, l.w, 0, l.h
+	for x0 < x1 && !col(x0) {
+		x0++
+	}
+	for x1 > x0 && !col(x1-1) {
+		x1--
+	}
+	for y0 < y1 && !row(y0) {
+		y0++
+	}
+	for y1 > y0 && !row(y1-1) {
+		y1--
+	}
+	if x0 == x1 || y0 == y1 {
+		return b
+	}
+	r := image.Rect(x0*l.scale, y0*l.scale, x1*l.scale, y1*l.scale)
+	if x1 == l.w {
+		r.Max.X = b.Dx() // include pixels lost to the reduction
+	}
+	if y1 == l.h {
+		r.Max.Y = b.Dy()
+	}
+	return r.Add(b.Min)
+}
+
+// Crop returns the part of m inside r.
+func Crop(m image.Image, r image.Rectangle) image.Image {
+	r = r.Intersect(m.Bounds())
+	dst := newLike(m, image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), m, r.Min, draw.Src)
+	return dst
+}
+
+// AutoCrop crops m to the paper boundary detected by PaperBounds.
+func AutoCrop(m image.Image) image.Image {
+	return Crop(m, PaperBounds(m))
+}
@@ -0,0 +1,153 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Interpolation is a method for sampling an image between pixel centers.
+type Interpolation int
+
+// Interpolation constants.
+const (
+	NearestNeighbor Interpolation = iota // fast, keeps lineart bilevel
+	Bilinear                             // smoother, better for photos
+)
+
+// DeskewOptions controls skew correction.
+type DeskewOptions struct {
+	MaxAngle float64       // largest skew considered, in degrees
+	Interp   Interpolation // interpolation used for rotation
+}
+
+// DefaultDeskewOptions are reasonable settings for sheet-fed documents.
+var DefaultDeskewOptions = DeskewOptions{
+	MaxAngle: 5,
+	Interp:   Bilinear,
+}
+
+// Skew estimates the skew angle of m in degrees, up to maxAngle in either
+// direction. A positive angle means that the contents are rotated clockwise;
+// rotating by the opposite angle straightens them.
+//
+// The estimate maximizes the concentration of the horizontal projection
+// profile of the dark pixels, so it works best on pages containing text or
+// other horizontal features.
+func Skew(m image.Image, maxAngle float64) float64 {
+	l := newLumaMap(m)
+	ink := 0.5 * l.median(l.center())
+	var xs, ys []float64
+	for y := 0; y < l.h; y++ {
+		for x := 0; x < l.w; x++ {
+			if l.at(x, y) < ink {
+				xs = append(xs, float64(x))
+				ys = append(ys, float64(y))
+			}
+		}
+	}
+	if len(xs) == 0 {
+		return 0
+	}
+
+	// score is the sum of squares of the projection profile at angle a.
+	bins := make(map[int]int)
+	score := func(a float64) float64 {
+		for k := range bins {
+			delete(bins, k)
+		}
+		t := math.Tan(a * math.Pi / 180)
+		for i := range xs {
+			bins[int(math.Floor(ys[i]-xs[i]*t))]++
+		}
+		s := 0.0
+		for _, n := range bins {
+			s += float64(n) * float64(n)
+		}
+		return s
+	}
+	search := func(from, to, step float64) float64 {
+		best, bestScore := 0.0, -1.0
+		for a := from; a <= to+step/2; a += step {
+			if s := score(a); s > bestScore {
+				best, bestScore = a, s
+			}
+		}
+		return best
+	}
+
+	// Coarse search followed by a finer one around the best angle.
+	a := search(-maxAngle, maxAngle, 0.5)
+	return search(a-0.5, a+0.5, 0.05)
+}
+
+// Rotate rotates m clockwise by deg degrees around its center. The bounds are
+// unchanged; areas not covered by the rotated image are filled with bg.
+func Rotate(m image.Image, deg float64, interp Interpolation, bg color.Color) image.Image {
+	b := m.Bounds()
+	dst := newLike(m, b)
+	sin, cos := math.Sincos(deg * math.Pi / 180)
+	cx := float64(b.Min.X+b.Max.X) / 2
+	cy := float64(b.Min.Y+b.Max.Y) / 2
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			// Map the center of the destination pixel back to the source.
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			sx := cos*dx + sin*dy + cx
+			sy := -sin*dx + cos*dy + cy
+			var c color.Color
+			if interp == Bilinear {
+				c = bilinear(m, sx-0.5, sy-0.5, bg)
+			} else {
+				c = nearest(m, sx, sy, bg)
+			}
+			dst.Set(x, y, c)
+		}
+	}
+	return dst
+}
+
+func nearest(m image.Image, x, y float64, bg color.Color) color.Color {
+	p := image.Pt(int(math.Floor(x)), int(math.Floor(y)))
+	if !p.In(m.Bounds()) {
+		return bg
+	}
+	return m.At(p.X, p.Y)
+}
+
+func bilinear(m image.Image, x, y float64, bg color.Color) color.Color {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	b := m.Bounds()
+	var sum [4]float64
+	for i, w := range [4]float64{(1 - fx) * (1 - fy), fx * (1 - fy), (1 - fx) * fy, fx * fy} {
+		p := image.Pt(int(x0)+i%2, int(y0)+i/2)
+		c := bg
+		if p.In(b) {
+			c = m.At(p.X, p.Y)
+		}
+		r, g, bl, a := c.RGBA()
+		sum[0] += w * float64(r)
+		sum[1] += w * float64(g)
+		sum[2] += w * float64(bl)
+		sum[3] += w * float64(a)
+	}
+	return color.RGBA64{
+		uint16(sum[0] + 0.5),
+		uint16(sum[1] + 0.5),
+		uint16(sum[2] + 0.5),
+		uint16(sum[3] + 0.5)}
+}
+
+// Deskew estimates the skew of m and rotates it to compensate. Areas
+// uncovered by the rotation are filled with the color found along the edges
+// of m, usually the scanner backing, so that AutoCrop removes them.
+func Deskew(m image.Image, opts DeskewOptions) image.Image {
+	a := Skew(m, opts.MaxAngle)
+	bg := color.Gray16{uint16(newLumaMap(m).border() * 0xffff)}
+	return Rotate(m, -a, opts.Interp, bg)
+}
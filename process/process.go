@@ -0,0 +1,107 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package process implements post-processing of scanned images.
+//
+// The geometric functions, such as Deskew and AutoCrop, accept any
+// image.Image, including the *sane.Image values returned by
+// sane.Conn.ReadImage. They return an image of a standard library type with
+// the same color model as the input.
+//
+// The tonal functions, such as Gray, Adjust, Otsu and Dither, operate on
+// *sane.Image values and return new ones, which keep the resolution and scan
+// area of the input and can be passed to the encoders in package sane.
+package process
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// Longest side of the reduced images used for analysis, in pixels.
+const analysisSize = 1000
+
+// newLike returns a new image with bounds r and the same color model as m.
+func newLike(m image.Image, r image.Rectangle) draw.Image {
+	switch m.ColorModel() {
+	case color.GrayModel:
+		return image.NewGray(r)
+	case color.Gray16Model:
+		return image.NewGray16(r)
+	case color.RGBAModel:
+		return image.NewRGBA(r)
+	case color.NRGBAModel:
+		return image.NewNRGBA(r)
+	}
+	return image.NewRGBA64(r)
+}
+
+// luma returns the luminance of c, from 0 to 1.
+func luma(c color.Color) float64 {
+	r, g, b, _ := c.RGBA()
+	return (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 0xffff
+}
+
+// A lumaMap is a reduced grayscale copy of an image, used for analysis.
+type lumaMap struct {
+	w, h  int       // size in samples
+	scale int       // pixels per sample in each direction
+	v     []float64 // luminance of each sample, row by row
+}
+
+func newLumaMap(m image.Image) *lumaMap {
+	b := m.Bounds()
+	s := 1
+	for b.Dx()/s > analysisSize || b.Dy()/s > analysisSize {
+		s++
+	}
+	l := &lumaMap{w: b.Dx() / s, h: b.Dy() / s, scale: s}
+	l.v = make([]float64, l.w*l.h)
+	for y := 0; y < l.h; y++ {
+		for x := 0; x < l.w; x++ {
+			l.v[y*l.w+x] = luma(m.At(b.Min.X+x*s, b.Min.Y+y*s))
+		}
+	}
+	return l
+}
+
+func (l *lumaMap) at(x, y int) float64 {
+	return l.v[y*l.w+x]
+}
+
+// median returns the median of the samples in r.
+func (l *lumaMap) median(r image.Rectangle) float64 {
+	var vs []float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		vs = append(vs, l.v[y*l.w+r.Min.X:y*l.w+r.Max.X]...)
+	}
+	if len(vs) == 0 {
+		return 1
+	}
+	sort.Float64s(vs)
+	return vs[len(vs)/2]
+}
+
+// border returns the median of the samples along the edges of the map.
+func (l *lumaMap) border() float64 {
+	if l.w == 0 || l.h == 0 {
+		return 1 // too thin to have been reduced to any samples
+	}
+	var vs []float64
+	for x := 0; x < l.w; x++ {
+		vs = append(vs, l.at(x, 0), l.at(x, l.h-1))
+	}
+	for y := 0; y < l.h; y++ {
+		vs = append(vs, l.at(0, y), l.at(l.w-1, y))
+	}
+	sort.Float64s(vs)
+	return vs[len(vs)/2]
+}
+
+// center returns the middle half of the map in each direction.
+func (l *lumaMap) center() image.Rectangle {
+	return image.Rect(l.w/4, l.h/4, l.w-l.w/4, l.h-l.h/4)
+}
@@ -0,0 +1,192 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package process
+
+import (
+	"github.com/tjgq/sane"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// page returns a white page with horizontal black lines, like text.
+func page(w, h int) *image.Gray {
+	m := image.NewGray(image.Rect(0, 0, w, h))
+	draw.Draw(m, m.Bounds(), image.White, image.Point{}, draw.Src)
+	for y := h / 10; y < h-h/10; y += 20 {
+		r := image.Rect(w/10, y, w-w/10, y+4)
+		draw.Draw(m, r, image.Black, image.Point{}, draw.Src)
+	}
+	return m
+}
+
+func TestSkew(t *testing.T) {
+	for _, a := range []float64{-3, -1, 0, 1.5, 4} {
+		m := Rotate(page(600, 800), a, Bilinear, color.White)
+		if m.ColorModel() != color.GrayModel {
+			t.Fatalf("bad color model: %v", m.ColorModel())
+		}
+		if s := Skew(m, 5); math.Abs(s-a) > 0.2 {
+			t.Errorf("skew of page rotated by %v is %v", a, s)
+		}
+		if s := Skew(Deskew(m, DefaultDeskewOptions), 5); math.Abs(s) > 0.2 {
+			t.Errorf("skew of deskewed page rotated by %v is %v", a, s)
+		}
+	}
+}
+
+func TestAutoCrop(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 400, 300))
+	draw.Draw(m, m.Bounds(), image.Black, image.Point{}, draw.Src)
+	paper := image.Rect(20, 10, 380, 280)
+	draw.Draw(m, paper, image.White, image.Point{}, draw.Src)
+	if r := PaperBounds(m); r != paper {
+		t.Errorf("paper bounds are %v, should be %v", r, paper)
+	}
+	if b := AutoCrop(m).Bounds(); b.Dx() != paper.Dx() || b.Dy() != paper.Dy() {
+		t.Errorf("cropped image has bounds %v", b)
+	}
+	if r := PaperBounds(page(400, 300)); r != image.Rect(0, 0, 400, 300) {
+		t.Errorf("paper bounds without backing are %v", r)
+	}
+}
+
+func TestThinImage(t *testing.T) {
+	// The reduced copies of these images have no rows or no columns.
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 2000, 1), image.Rect(0, 0, 1, 2000)} {
+		m := image.NewGray(r)
+		if s := Skew(m, 5); s != 0 {
+			t.Errorf("skew of %v image is %v", r, s)
+		}
+		if b := Deskew(m, DefaultDeskewOptions).Bounds(); b != r {
+			t.Errorf("deskewed %v image has bounds %v", r, b)
+		}
+		if b := AutoCrop(m).Bounds(); b != r {
+			t.Errorf("cropped %v image has bounds %v", r, b)
+		}
+	}
+}
+
+// scan returns a copy of m as an 8-bit gray *sane.Image at 300 dpi.
+func scan(t *testing.T, m *image.Gray) *sane.Image {
+	b := m.Bounds()
+	f, err := sane.NewFrame(sane.FrameGray, b.Dx(), b.Dy(), 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			f.Set(x, y, 0, uint16(m.GrayAt(x, y).Y))
+		}
+	}
+	s, err := sane.NewImage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.XRes, s.YRes = 300, 300
+	return s
+}
+
+// shade returns page(w, h) with a dark gradient across it, as if unevenly lit.
+func shade(w, h int) *image.Gray {
+	m := page(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := m.GrayAt(x, y).Y
+			m.SetGray(x, y, color.Gray{uint8(int(v) * (w + 2*x) / (3 * w))})
+		}
+	}
+	return m
+}
+
+// mismatch returns the fraction of pixels of the lineart image m that differ
+// from the black and white image ref.
+func mismatch(m *sane.Image, ref *image.Gray) float64 {
+	b := ref.Bounds()
+	n := 0
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			v, _, _, _ := m.At(x, y).RGBA()
+			if (v != 0) != (ref.GrayAt(x, y).Y != 0) {
+				n++
+			}
+		}
+	}
+	return float64(n) / float64(b.Dx()*b.Dy())
+}
+
+func TestBilevel(t *testing.T) {
+	ref := page(200, 150)
+	for _, c := range []struct {
+		name string
+		m    *sane.Image
+	}{
+		{"otsu", Otsu(scan(t, ref))},
+		{"sauvola", Sauvola(scan(t, shade(200, 150)), DefaultSauvolaOptions)},
+	} {
+		if c.m.ColorModel() != color.GrayModel || c.m.XRes != 300 {
+			t.Fatalf("%s: bad result: %v at %v dpi", c.name, c.m.ColorModel(), c.m.XRes)
+		}
+		if e := mismatch(c.m, ref); e > 0.01 {
+			t.Errorf("%s: %.1f%% of pixels are wrong", c.name, 100*e)
+		}
+	}
+	if e := mismatch(Otsu(scan(t, shade(200, 150))), ref); e < 0.05 {
+		t.Errorf("otsu: only %.1f%% of pixels are wrong on uneven page", 100*e)
+	}
+
+	// Dithering mid-gray should give about half white pixels.
+	gray := image.NewGray(image.Rect(0, 0, 64, 64))
+	draw.Draw(gray, gray.Bounds(), image.NewUniform(color.Gray{128}), image.Point{}, draw.Src)
+	d := Dither(scan(t, gray))
+	n := 0
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if v, _, _, _ := d.At(x, y).RGBA(); v != 0 {
+				n++
+			}
+		}
+	}
+	if n < 64*64*45/100 || n > 64*64*55/100 {
+		t.Errorf("dithered mid-gray has %d white pixels out of %d", n, 64*64)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	f, _ := sane.NewFrame(sane.FrameRgb, 2, 1, 16)
+	f.Set(0, 0, 0, 0xffff) // red
+	f.Set(1, 0, 2, 0x8000) // dark blue
+	m, err := sane.NewImage(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := Gray(m)
+	if g.ColorModel() != color.Gray16Model {
+		t.Fatalf("gray image has color model %v", g.ColorModel())
+	}
+	if v, _, _, _ := g.At(0, 0).RGBA(); v != 19595 {
+		t.Errorf("luminance of red is %d", v)
+	}
+
+	r := Reduce(m)
+	if r.ColorModel() != color.RGBAModel {
+		t.Fatalf("reduced image has color model %v", r.ColorModel())
+	}
+	if c := r.At(1, 0).(color.RGBA); c != (color.RGBA{0, 0, 0x80, 0xff}) {
+		t.Errorf("reduced dark blue is %v", c)
+	}
+
+	a := Adjust(m, Adjustment{Gamma: 2})
+	if _, _, b, _ := a.At(1, 0).RGBA(); b != uint32(math.Sqrt(0x8000/65535.0)*0xffff+0.5) {
+		t.Errorf("gamma-corrected dark blue is %d", b)
+	}
+	a = Adjust(m, Adjustment{Brightness: 0.5})
+	if r, g, _, _ := a.At(0, 0).RGBA(); r != 0xffff || g != 0x8000 {
+		t.Errorf("brightened red is %d,%d", r, g)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// A Profile is a set of option values by option name, such as the settings
+// used for a kind of document. It can be saved as JSON and applied to a
+// connection with ApplyProfile.
+type Profile map[string]interface{}
+
+// Profile returns the values of the active, settable options, which
+// together describe the current settings of the device.
+func (c *Conn) Profile() (Profile, error) {
+	p := make(Profile)
+	for _, o := range c.Options() {
+		if !o.IsActive || !o.IsSettable || !hasValue(o.Type) {
+			continue
+		}
+		v, err := c.GetOption(o.Name)
+		if err != nil {
+			return nil, fmt.Errorf("option %s: %v", o.Name, err)
+		}
+		p[o.Name] = v
+	}
+	return p, nil
+}
+
+// ApplyProfile sets the options in p in the order in which the device lists
+// them, since the range or availability of an option may depend on earlier
+// ones. Values decoded from JSON are converted with ConvertValue. Options
+// that are inactive when their turn comes are skipped.
+func (c *Conn) ApplyProfile(p Profile) error {
+	done := make(map[string]bool)
+	// Options may be reloaded as others are set, so look them up afresh.
+	for i := 0; i < len(c.Options()); i++ {
+		o := c.Options()[i]
+		v, ok := p[o.Name]
+		if !ok || done[o.Name] {
+			continue
+		}
+		done[o.Name] = true
+		if !o.IsActive {
+			continue
+		}
+		cv, err := ConvertValue(o, v)
+		if err != nil {
+			return err
+		}
+		if _, err := c.SetOption(o.Name, cv); err != nil {
+			return fmt.Errorf("option %s: %v", o.Name, err)
+		}
+	}
+	for name := range p {
+		if !done[name] {
+			return fmt.Errorf("no option named %s", name)
+		}
+	}
+	return nil
+}
+
+// ConvertValue converts v, such as a value decoded from JSON, to the type
+// expected by SetOption for o. Numbers may be given as int or float64, as
+// long as integer options get whole numbers. Arrays may be given as
+// []interface{} or as a slice of any option type. The string "auto" selects
+// the automatic value of options that have one.
+func ConvertValue(o Option, v interface{}) (interface{}, error) {
+	if s, ok := v.(string); ok && s == "auto" && o.IsAutomatic && o.Type != TypeString {
+		return Auto, nil
+	}
+	var vs []interface{}
+	switch v := v.(type) {
+	case []interface{}:
+		vs = v
+	case []bool, []int, []float64:
+		rv := reflect.ValueOf(v)
+		for i := 0; i < rv.Len(); i++ {
+			vs = append(vs, rv.Index(i).Interface())
+		}
+	default:
+		return convertScalar(o, v)
+	}
+	var t reflect.Type
+	switch o.Type {
+	case TypeBool:
+		t = boolType
+	case TypeInt:
+		t = intType
+	case TypeFloat:
+		t = floatType
+	default:
+		return nil, fmt.Errorf("sane: option %s does not take an array", o.Name)
+	}
+	r := reflect.MakeSlice(reflect.SliceOf(t), len(vs), len(vs))
+	for i, x := range vs {
+		x, err := convertScalar(o, x)
+		if err != nil {
+			return nil, fmt.Errorf("sane: option %s expects an array of %s values", o.Name, o.Type)
+		}
+		r.Index(i).Set(reflect.ValueOf(x))
+	}
+	return r.Interface(), nil
+}
+
+// convertScalar converts a single value for ConvertValue.
+func convertScalar(o Option, v interface{}) (interface{}, error) {
+	switch o.Type {
+	case TypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case TypeInt:
+		switch x := v.(type) {
+		case int:
+			return x, nil
+		case float64:
+			if x == math.Trunc(x) && math.Abs(x) <= math.MaxInt32 {
+				return int(x), nil
+			}
+		}
+	case TypeFloat:
+		switch x := v.(type) {
+		case int:
+			return float64(x), nil
+		case float64:
+			return x, nil
+		}
+	case TypeString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("sane: option %s expects a %s value", o.Name, o.Type)
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+// Progress describes how far along a scan is.
+type Progress struct {
+	Page  int // index of the image being read, starting at 0
+	Frame int // index of the frame within the image, starting at 0
+	Bytes int // bytes read so far in the current frame
+	Lines int // lines completed so far in the current frame
+	Total int // expected bytes in the current frame, -1 if unknown
+}
+
+// Fraction returns the completed fraction of the current frame, between 0
+// and 1. The second return value is false if the total size is unknown, as is
+// the case with hand scanners.
+func (p Progress) Fraction() (float64, bool) {
+	if p.Total <= 0 {
+		return 0, false
+	}
+	f := float64(p.Bytes) / float64(p.Total)
+	if f > 1 {
+		f = 1 // backend underestimated the number of lines
+	}
+	return f, true
+}
+
+// progressReader wraps a connection and reports progress after every read.
+type progressReader struct {
+	c   *Conn
+	bpl int
+	p   Progress
+}
+
+func newProgressReader(c *Conn, p Params) *progressReader {
+	total := -1
+	if p.Lines > 0 {
+		total = p.Lines * p.BytesPerLine
+	}
+	return &progressReader{
+		c:   c,
+		bpl: p.BytesPerLine,
+		p: Progress{
+			Page:  c.page,
+			Frame: c.frame,
+			Total: total}}
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.c.Read(b)
+	if n > 0 && r.c.ProgressFunc != nil {
+		r.p.Bytes += n
+		if r.bpl > 0 {
+			r.p.Lines = r.p.Bytes / r.bpl
+		}
+		r.c.ProgressFunc(r.p)
+	}
+	return n, err
+}
@@ -64,6 +64,20 @@ const (
 	FrameBlue         = C.SANE_FRAME_BLUE
 )
 
+// Extended format constants. These are not part of the SANE 1.0 standard,
+// but are emitted by some backends.
+const (
+	FrameText  Format = 0x0a // text, such as OCR output
+	FrameJpeg         = 0x0b // JPEG-compressed image
+	FrameG31D         = 0x0c // CCITT Group 3 1-D compressed image
+	FrameG32D         = 0x0d // CCITT Group 3 2-D compressed image
+	FrameG42D         = 0x0e // CCITT Group 4 compressed image
+	FrameIr           = 0x0f // infrared channel
+	FrameRgbi         = 0x10 // interleaved red, green, blue and infrared
+	FrameGrayi        = 0x11 // interleaved gray and infrared
+	FrameXml          = 0x12 // XML metadata
+)
+
 // Info signals the side effects of setting an option.
 type Info struct {
 	Inexact      bool // option set to an approximate value
@@ -116,10 +130,20 @@ type Device struct {
 //
 // Conn implements the Reader interface. However, it only makes sense to call
 // Read after acquisition of a new frame is started by calling Start.
+//
+// If ProgressFunc is set, it is called periodically while ReadFrame or
+// ReadImage are in progress.
 type Conn struct {
-	Device  string // device name
-	handle  C.SANE_Handle
-	options []Option
+	Device       string         // device name
+	ProgressFunc func(Progress) // progress callback
+	handle       C.SANE_Handle
+	options      []Option
+	byName       map[string]int             // index of each option in options
+	page         int                        // number of images read so far
+	frame        int                        // index of the frame being read
+	known        map[string]interface{}     // option values read by the caller
+	changes      map[string]*OptionChange   // changes not yet returned by OptionsDiff
+	deps         map[string]map[string]bool // options affected by setting each option
 }
 
 // Params describes the properties of a frame.
@@ -149,6 +173,17 @@ var (
 	ErrDenied      = errors.New("sane: access denied")
 )
 
+// A FormatError reports a frame whose format or bit depth is not supported.
+type FormatError struct {
+	Format Format // frame format
+	Depth  int    // bits per sample
+}
+
+func (e *FormatError) Error() string {
+	return fmt.Sprintf("sane: unsupported frame format %d with bit depth %d",
+		int(e.Format), e.Depth)
+}
+
 // mkError converts a libsane status code to an Error.
 func mkError(s C.SANE_Status) Error {
 	switch s {
@@ -275,7 +310,7 @@ func Open(name string) (*Conn, error) {
 	if s := C.sane_open(strToSane(cname), &h); s != C.SANE_STATUS_GOOD {
 		return nil, mkError(s)
 	}
-	return &Conn{name, h, nil}, nil
+	return &Conn{Device: name, handle: h}, nil
 }
 
 // Start initiates the acquisition of a frame.
@@ -385,9 +420,22 @@ func (c *Conn) Options() (opts []Option) {
 		opts = append(opts, opt)
 	}
 	c.options = opts
+	c.byName = make(map[string]int, len(opts))
+	for i, o := range opts {
+		c.byName[o.Name] = i
+	}
 	return
 }
 
+// LookupOption returns the descriptor of the named option, and whether there
+// is such an option.
+func (c *Conn) LookupOption(name string) (Option, bool) {
+	if o := c.findOption(name); o != nil {
+		return *o, true
+	}
+	return Option{}, false
+}
+
 func readArrayAt(p unsafe.Pointer, i int, t reflect.Type) interface{} {
 	ptr := (*C.SANE_Word)(p)
 	switch t.Kind() {
@@ -413,33 +461,104 @@ func readArray(p unsafe.Pointer, t reflect.Type, n int) interface{} {
 	return v.Interface()
 }
 
+// getValue reads the value of o into buf, which must be at least o.size
+// bytes long, and returns it as a value of the appropriate type.
+// The value is recorded for OptionsDiff.
+func (c *Conn) getValue(o *Option, buf []byte) (interface{}, error) {
+	v, err := c.readValue(o, buf)
+	if err != nil {
+		return nil, err
+	}
+	if c.known == nil {
+		c.known = make(map[string]interface{})
+	}
+	c.known[o.Name] = v
+	return v, nil
+}
+
+// readValue is like getValue, but does not record the value, so that it does
+// not affect OptionsDiff.
+func (c *Conn) readValue(o *Option, buf []byte) (interface{}, error) {
+	var p unsafe.Pointer
+	if o.size > 0 {
+		p = unsafe.Pointer(&buf[0])
+	}
+	s := C.sane_control_option(c.handle, C.SANE_Int(o.index),
+		C.SANE_ACTION_GET_VALUE, p, nil)
+	if s != C.SANE_STATUS_GOOD {
+		return nil, mkError(s)
+	}
+	var v interface{}
+	switch o.Type {
+	case TypeBool:
+		v = readArray(p, boolType, o.Length)
+	case TypeInt:
+		v = readArray(p, intType, o.Length)
+	case TypeFloat:
+		v = readArray(p, floatType, o.Length)
+	case TypeString:
+		v = C.GoString(strFromSane(C.SANE_String_Const(p)))
+	}
+	return v, nil
+}
+
+// hasValue reports whether options of type t have a value.
+func hasValue(t Type) bool {
+	return t == TypeBool || t == TypeInt || t == TypeFloat || t == TypeString
+}
+
 // GetOption gets the current value for the named option. If successful, it
 // returns a value of the appropriate type for the option.
 func (c *Conn) GetOption(name string) (interface{}, error) {
-	var p unsafe.Pointer
-	for _, o := range c.Options() {
-		if o.Name == name {
-			if o.size > 0 {
-				p = unsafe.Pointer(&make([]byte, o.size)[0])
-			}
-			s := C.sane_control_option(c.handle, C.SANE_Int(o.index),
-				C.SANE_ACTION_GET_VALUE, p, nil)
-			if s != C.SANE_STATUS_GOOD {
-				return nil, mkError(s)
-			}
-			switch o.Type {
-			case TypeBool:
-				return readArray(p, boolType, o.Length), nil
-			case TypeInt:
-				return readArray(p, intType, o.Length), nil
-			case TypeFloat:
-				return readArray(p, floatType, o.Length), nil
-			case TypeString:
-				return C.GoString(strFromSane(C.SANE_String_Const(p))), nil
-			}
+	o := c.findOption(name)
+	if o == nil || !hasValue(o.Type) {
+		return nil, fmt.Errorf("no option named %s", name)
+	}
+	return c.getValue(o, make([]byte, o.size))
+}
+
+type inactiveType int
+
+// Inactive is the value reported by Values for inactive options.
+var Inactive = inactiveType(0)
+
+// Values reads the current values of all options at once, returning a map
+// from option names to values of the appropriate types. Inactive options are
+// mapped to Inactive, while options without a value, such as buttons, and
+// options whose value cannot be read are omitted.
+func (c *Conn) Values() map[string]interface{} {
+	return readValues(c.Options(), c.getValue)
+}
+
+// readValues reads the values of opts with get, as described for Values.
+func readValues(opts []Option, get func(o *Option, buf []byte) (interface{}, error)) map[string]interface{} {
+	size := 0
+	for _, o := range opts {
+		if o.size > size {
+			size = o.size
 		}
 	}
-	return nil, fmt.Errorf("no option named %s", name)
+	buf := make([]byte, size)
+	vals := make(map[string]interface{}, len(opts))
+	for i := range opts {
+		o := &opts[i]
+		switch {
+		case !hasValue(o.Type) || !o.IsDetectable:
+			continue
+		case !o.IsActive:
+			vals[o.Name] = Inactive
+			continue
+		}
+		for j := range buf[:o.size] {
+			buf[j] = 0
+		}
+		v, err := get(o, buf)
+		if err != nil {
+			continue // one unreadable option should not hide the others
+		}
+		vals[o.Name] = v
+	}
+	return vals
 }
 
 func fillOpt(o Option, v interface{}) (unsafe.Pointer, error) {
@@ -450,6 +569,10 @@ func fillOpt(o Option, v interface{}) (unsafe.Pointer, error) {
 	s := ""
 	if l > 1 {
 		s = "[]"
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice && rv.Len() != l {
+			return nil, fmt.Errorf("option %s expects %d values, got %d",
+				o.Name, l, rv.Len())
+		}
 	}
 
 	switch o.Type {
@@ -517,39 +640,39 @@ func (c *Conn) SetOption(name string, v interface{}) (info Info, err error) {
 		s C.SANE_Status
 		i C.SANE_Int
 	)
-	for _, o := range c.Options() {
-		if o.Name == name {
-			if _, ok := v.(autoType); ok {
-				// automatic mode
-				s = C.sane_control_option(c.handle, C.SANE_Int(o.index),
-					C.SANE_ACTION_SET_AUTO, nil, &i)
-			} else {
-				p, err := fillOpt(o, v)
-				if err != nil {
-					return info, err
-				}
-				s = C.sane_control_option(c.handle, C.SANE_Int(o.index),
-					C.SANE_ACTION_SET_VALUE, p, &i)
-			}
-
-			if s != C.SANE_STATUS_GOOD {
-				return info, mkError(s)
-			}
-
-			if int(i)&C.SANE_INFO_INEXACT != 0 {
-				info.Inexact = true
-			}
-			if int(i)&C.SANE_INFO_RELOAD_OPTIONS != 0 {
-				info.ReloadOpts = true
-				c.options = nil // cached options are no longer valid
-			}
-			if int(i)&C.SANE_INFO_RELOAD_PARAMS != 0 {
-				info.ReloadParams = true
-			}
-			return info, nil
+	o := c.findOption(name)
+	if o == nil {
+		return info, fmt.Errorf("no option named %s", name)
+	}
+	if _, ok := v.(autoType); ok {
+		// automatic mode
+		s = C.sane_control_option(c.handle, C.SANE_Int(o.index),
+			C.SANE_ACTION_SET_AUTO, nil, &i)
+	} else {
+		p, err := fillOpt(*o, v)
+		if err != nil {
+			return info, err
 		}
+		s = C.sane_control_option(c.handle, C.SANE_Int(o.index),
+			C.SANE_ACTION_SET_VALUE, p, &i)
+	}
+
+	if s != C.SANE_STATUS_GOOD {
+		return info, mkError(s)
+	}
+
+	if int(i)&C.SANE_INFO_INEXACT != 0 {
+		info.Inexact = true
+	}
+	delete(c.known, name) // may differ from v if inexact
+	if int(i)&C.SANE_INFO_RELOAD_OPTIONS != 0 {
+		info.ReloadOpts = true
+		c.reload(name, c.options) // cached options are no longer valid
+	}
+	if int(i)&C.SANE_INFO_RELOAD_PARAMS != 0 {
+		info.ReloadParams = true
 	}
-	return info, fmt.Errorf("no option named %s", name)
+	return info, nil
 }
 
 // Params retrieves the current scanning parameters. The parameters are
@@ -597,4 +720,5 @@ func (c *Conn) Close() {
 	C.sane_close(c.handle)
 	c.handle = nil
 	c.options = nil
+	c.known = nil
 }
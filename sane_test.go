@@ -5,10 +5,18 @@
 package sane
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"image"
 	"image/color"
+	"image/jpeg"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const TestDevice = "test" // the sane test device
@@ -20,40 +28,6 @@ var not = map[bool]string{
 	false: "not ",
 }
 
-var typeMap = map[Type]string{
-	TypeBool:   "bool",
-	TypeInt:    "int",
-	TypeFloat:  "float",
-	TypeString: "string",
-	TypeButton: "button",
-}
-
-func typeName(t Type) string {
-	s, ok := typeMap[t]
-	if ok {
-		return s
-	}
-	return fmt.Sprintf("(unknown type with value %d)", int(t))
-}
-
-var unitMap = map[Unit]string{
-	UnitNone:    "none",
-	UnitPixel:   "pixel",
-	UnitBit:     "bit",
-	UnitMm:      "mm",
-	UnitDpi:     "dpi",
-	UnitPercent: "percent",
-	UnitUsec:    "milliseconds",
-}
-
-func unitName(u Unit) string {
-	s, ok := unitMap[u]
-	if ok {
-		return s
-	}
-	return fmt.Sprintf("(unknown unit with value %d)", int(u))
-}
-
 // Test options provided by the sane test device.
 var testOpts = []Option{
 	{
@@ -254,11 +228,11 @@ func checkOption(t *testing.T, actual, expected *Option) {
 	}
 	if actual.Type != expected.Type {
 		t.Errorf("option %s has wrong type: %s should be %s",
-			actual.Name, typeName(actual.Type), typeName(expected.Type))
+			actual.Name, actual.Type, expected.Type)
 	}
 	if actual.Unit != expected.Unit {
 		t.Errorf("option %s has wrong unit: %s should be %s",
-			actual.Name, unitName(actual.Unit), unitName(expected.Unit))
+			actual.Name, actual.Unit, expected.Unit)
 	}
 	if actual.Length != expected.Length {
 		t.Errorf("option %s has wrong length: %d should be %d",
@@ -318,7 +292,7 @@ func checkOptionType(t *testing.T, o *Option, val interface{}) {
 	}
 	if !ok {
 		t.Errorf("get option %s returned %s, should return %s",
-			o.Name, valType, typeName(o.Type))
+			o.Name, valType, o.Type)
 	}
 }
 
@@ -779,3 +753,847 @@ func TestThreePass16(t *testing.T) {
 func TestGray16(t *testing.T) {
 	runGrayTest(t, 16, 1, nil)
 }
+
+func TestProgress(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		setOption(t, c, "mode", "Color")
+		setOption(t, c, "three-pass", true)
+		var last Progress
+		c.ProgressFunc = func(p Progress) {
+			if p.Frame == last.Frame && p.Bytes <= last.Bytes {
+				t.Fatalf("progress went backwards: %v after %v", p, last)
+			}
+			last = p
+		}
+		readImage(t, c)
+		if last.Frame != 2 {
+			t.Fatalf("last progress in frame %d, should be 2", last.Frame)
+		}
+		if f, ok := last.Fraction(); !ok || f != 1 {
+			t.Fatalf("last progress is %v, should be complete", last)
+		}
+	})
+}
+
+func TestPreview(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		setOption(t, c, "resolution", 200.0)
+		setOption(t, c, "br-x", 100.0)
+		p, err := c.Preview(context.Background())
+		if err != nil {
+			t.Fatal("preview failed:", err)
+		}
+		if v := getOption(t, c, "resolution"); v != 200.0 {
+			t.Errorf("resolution not restored: %v should be 200", v)
+		}
+		if v := getOption(t, c, "br-x"); v != 100.0 {
+			t.Errorf("br-x not restored: %v should be 100", v)
+		}
+		b := p.Bounds()
+		r := p.Region(b)
+		if r != p.Area {
+			t.Errorf("region for whole preview is %v, should be %v", r, p.Area)
+		}
+		r = p.Region(image.Rect(0, 0, b.Dx()/2, b.Dy()/2))
+		if r.TLX != p.Area.TLX || r.BRX >= p.Area.BRX || r.BRY >= p.Area.BRY {
+			t.Errorf("region for half preview is %v", r)
+		}
+		if err := c.SetRegion(r); err != nil {
+			t.Fatal("set region failed:", err)
+		}
+	})
+}
+
+func TestReadValues(t *testing.T) {
+	opts := []Option{
+		{Name: "bad", Type: TypeInt, IsActive: true, IsDetectable: true, size: 4},
+		{Name: "good", Type: TypeInt, IsActive: true, IsDetectable: true, size: 4},
+		{Name: "off", Type: TypeInt, IsDetectable: true, size: 4},
+		{Name: "button", Type: TypeButton, IsActive: true},
+	}
+	vals := readValues(opts, func(o *Option, buf []byte) (interface{}, error) {
+		if o.Name == "bad" {
+			return nil, ErrIo
+		}
+		return 1, nil
+	})
+	expected := map[string]interface{}{"good": 1, "off": Inactive}
+	if !reflect.DeepEqual(vals, expected) {
+		t.Errorf("values are %v, expected %v", vals, expected)
+	}
+}
+
+func TestEmptyPreviewRegion(t *testing.T) {
+	m := &Image{}
+	m.fs[0] = newFrame(FrameGray, 0, 5, 1, 8, true)
+	p := &Preview{Image: m, Area: Region{0, 0, 210, 297, UnitMm}}
+	if r := p.Region(image.Rect(0, 0, 10, 10)); r != (Region{}) {
+		t.Errorf("region of empty preview is %+v", r)
+	}
+}
+
+func TestBlank(t *testing.T) {
+	pictures := []struct {
+		name  string
+		blank bool
+	}{
+		{"Solid white", true},
+		{"Solid black", false},
+		{"Color pattern", false},
+		{"Grid", false},
+	}
+	for _, depth := range []int{1, 8, 16} {
+		runTest(t, len(pictures), func(i int, c *Conn) {
+			setOption(t, c, "mode", "Gray")
+			setOption(t, c, "depth", depth)
+			setOption(t, c, "test-picture", pictures[i].name)
+			setResAndSize(t, c, depth)
+			m := readImage(t, c)
+			if m.IsBlank(DefaultBlankOptions) != pictures[i].blank {
+				t.Errorf("%s at depth %d should %sbe blank",
+					pictures[i].name, depth, not[pictures[i].blank])
+			}
+		})
+	}
+}
+
+func TestBlankFrames(t *testing.T) {
+	const w, h = 100, 100
+	pages := []struct {
+		name  string
+		blank bool
+		level func(x, y int) float64 // from 0 for black to 1 for white
+	}{
+		{"white", true, func(x, y int) float64 { return 1 }},
+		{"black", false, func(x, y int) float64 { return 0 }},
+		{"mostly dark", false, func(x, y int) float64 {
+			if x < 60 {
+				return 0
+			}
+			return 1
+		}},
+		{"speck", true, func(x, y int) float64 {
+			if x == 50 && y == 50 {
+				return 0
+			}
+			return 1
+		}},
+		{"tinted", true, func(x, y int) float64 { return 0.8 }},
+	}
+	for _, depth := range []int{1, 8, 16} {
+		for _, p := range pages {
+			if depth == 1 && p.name == "tinted" {
+				continue
+			}
+			f, err := NewFrame(FrameGray, w, h, depth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			max := float64(uint(1)<<uint(depth) - 1)
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					f.Set(x, y, 0, uint16(p.level(x, y)*max+0.5))
+				}
+			}
+			if f.IsBlank(DefaultBlankOptions) != p.blank {
+				t.Errorf("%s page at depth %d should %sbe blank", p.name, depth, not[p.blank])
+			}
+		}
+	}
+}
+
+// patternImage returns an image with distinct pixel values, made of a single
+// interleaved frame or of three separate ones.
+func patternImage(w, h, depth int, threePass bool) *Image {
+	m := Image{}
+	if threePass {
+		for i, format := range []Format{FrameRed, FrameGreen, FrameBlue} {
+			m.fs[i] = newFrame(format, w, h, 1, depth, i == 2)
+		}
+	} else {
+		m.fs[0] = newFrame(FrameRgb, w, h, 3, depth, true)
+	}
+	for _, f := range m.fs {
+		if f == nil {
+			continue
+		}
+		for i := range f.data {
+			f.data[i] = byte(i*7 + int(f.Format))
+		}
+	}
+	return &m
+}
+
+func TestRotateFlip(t *testing.T) {
+	const w, h = 13, 5
+	tests := []struct {
+		name string
+		f    func(m *Image) *Image
+		w, h int
+		src  func(x, y int) (int, int)
+	}{
+		{"rotate 90", func(m *Image) *Image { r, _ := m.Rotate(90); return r },
+			h, w, func(x, y int) (int, int) { return y, h - 1 - x }},
+		{"rotate 180", func(m *Image) *Image { r, _ := m.Rotate(-180); return r },
+			w, h, func(x, y int) (int, int) { return w - 1 - x, h - 1 - y }},
+		{"rotate 270", func(m *Image) *Image { r, _ := m.Rotate(270); return r },
+			h, w, func(x, y int) (int, int) { return w - 1 - y, x }},
+		{"flip horizontal", func(m *Image) *Image { return m.Flip(true) },
+			w, h, func(x, y int) (int, int) { return w - 1 - x, y }},
+		{"flip vertical", func(m *Image) *Image { return m.Flip(false) },
+			w, h, func(x, y int) (int, int) { return x, h - 1 - y }},
+	}
+	for _, depth := range []int{1, 4, 8, 12, 16} {
+		for _, threePass := range []bool{false, true} {
+			m := patternImage(w, h, depth, threePass)
+			for _, tt := range tests {
+				r := tt.f(m)
+				if b := r.Bounds(); b.Dx() != tt.w || b.Dy() != tt.h {
+					t.Fatalf("%s: bad bounds %v", tt.name, b)
+				}
+				for y := 0; y < tt.h; y++ {
+					for x := 0; x < tt.w; x++ {
+						sx, sy := tt.src(x, y)
+						if r.At(x, y) != m.At(sx, sy) {
+							t.Fatalf("%s at depth %d: bad pixel at (%d,%d)",
+								tt.name, depth, x, y)
+						}
+					}
+				}
+			}
+		}
+	}
+	if _, err := patternImage(w, h, 8, false).Rotate(45); err == nil {
+		t.Errorf("rotate by 45 degrees should fail")
+	}
+
+	m := patternImage(w, h, 8, false)
+	m.XRes, m.YRes, m.Area = 100, 200, Region{0, 0, 10, 20, UnitMm}
+	r, _ := m.Rotate(90)
+	if r.XRes != 200 || r.YRes != 100 || r.Area != (Region{}) {
+		t.Errorf("rotated image has resolution %vx%v and area %+v", r.XRes, r.YRes, r.Area)
+	}
+	if r, _ := m.Rotate(360); r.Area != m.Area {
+		t.Errorf("rotation by 360 degrees lost the area")
+	}
+}
+
+func TestNative(t *testing.T) {
+	const w, h = 13, 5
+	for _, depth := range []int{1, 4, 8, 12, 16} {
+		gray := &Image{}
+		gray.fs[0] = newFrame(FrameGray, w, h, 1, depth, true)
+		for i := range gray.fs[0].data {
+			gray.fs[0].data[i] = byte(i * 11)
+		}
+		for _, m := range []*Image{gray, patternImage(w, h, depth, false), patternImage(w, h, depth, true)} {
+			n := m.Native()
+			if n.ColorModel() != m.ColorModel() {
+				t.Fatalf("native image has color model %v, should be %v",
+					n.ColorModel(), m.ColorModel())
+			}
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					if n.At(x, y) != m.At(x, y) {
+						t.Fatalf("bad pixel at (%d,%d) for depth %d: %v should be %v",
+							x, y, depth, n.At(x, y), m.At(x, y))
+					}
+				}
+			}
+			r := image.Rect(2, 1, 7, 4)
+			s := m.SubImage(r)
+			if s.Bounds() != r || s.At(3, 2) != m.At(3, 2) {
+				t.Fatalf("bad subimage for depth %d", depth)
+			}
+		}
+	}
+}
+
+func TestInfrared(t *testing.T) {
+	const w, h = 13, 5
+	for _, depth := range []int{8, 16} {
+		m := &Image{}
+		m.fs[0] = newFrame(FrameRgbi, w, h, 4, depth, true)
+		for i := range m.fs[0].data {
+			m.fs[0].data[i] = byte(i * 3)
+		}
+		ir := m.Infrared()
+		if ir == nil {
+			t.Fatalf("no infrared channel at depth %d", depth)
+		}
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, _, _, _ := ir.At(x, y).RGBA()
+				if v := m.fs[0].at16(x, y, 3); uint16(r) != v {
+					t.Fatalf("bad infrared sample at (%d,%d): %d should be %d",
+						x, y, r, v)
+				}
+			}
+		}
+		if c := m.ColorModel(); (depth == 8) != (c == color.RGBAModel) {
+			t.Fatalf("bad color model: %v", c)
+		}
+	}
+	if patternImage(4, 4, 8, false).Infrared() != nil {
+		t.Fatalf("infrared channel in RGB image")
+	}
+}
+
+func TestEncoded(t *testing.T) {
+	src := patternImage(16, 8, 8, false).ToRGBA()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, nil); err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	m := &Image{encoded: &Frame{
+		Format:   FrameJpeg,
+		Width:    16,
+		Height:   8,
+		Channels: 3,
+		Depth:    8,
+		IsLast:   true,
+		Encoded:  buf.Bytes(),
+		MimeType: "image/jpeg"}}
+	if err := m.Decode(); err != nil {
+		t.Fatal("decode failed:", err)
+	}
+	if b := m.Bounds(); b.Dx() != 16 || b.Dy() != 8 {
+		t.Fatalf("bad bounds: %v", b)
+	}
+	var out bytes.Buffer
+	if err := EncodeJPEG(&out, m, nil); err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	if !bytes.Equal(out.Bytes(), buf.Bytes()) {
+		t.Errorf("JPEG data was recompressed")
+	}
+	out.Reset()
+	if err := EncodePDF(&out, []*Image{m, patternImage(4, 4, 1, false)}); err != nil {
+		t.Fatal("PDF encode failed:", err)
+	}
+	if !bytes.Contains(out.Bytes(), buf.Bytes()) {
+		t.Errorf("JPEG data not embedded in PDF")
+	}
+	if !bytes.Contains(out.Bytes(), []byte("/Count 2")) {
+		t.Errorf("PDF does not have two pages")
+	}
+	m = &Image{encoded: m.encoded}
+	out.Reset()
+	if err := EncodePDF(&out, []*Image{m}); err != nil {
+		t.Fatal("PDF encode failed:", err)
+	}
+	if m.fs[0] != nil {
+		t.Errorf("JPEG data was decoded for PDF output")
+	}
+}
+
+func TestReadEncodedImage(t *testing.T) {
+	c := &Conn{}
+	g4 := &Frame{
+		Format:   FrameG42D,
+		Width:    16,
+		Height:   8,
+		Channels: 1,
+		Depth:    1,
+		IsLast:   true,
+		Encoded:  []byte{0},
+		MimeType: "image/x-ccitt-g4"}
+	_, err := c.readImage(func() (*Frame, error) { return g4, nil })
+	if err == nil || !strings.Contains(err.Error(), "ReadFrame") {
+		t.Errorf("G4 frame gave error %v, want one pointing to ReadFrame", err)
+	}
+}
+
+func TestResolution(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		setOption(t, c, "resolution", 200.0)
+		m := readImage(t, c)
+		if m.XRes != 200 || m.YRes != 200 {
+			t.Fatalf("bad resolution: %vx%v should be 200x200", m.XRes, m.YRes)
+		}
+		if m.Area.Unit != UnitMm || m.Area.BRX <= m.Area.TLX {
+			t.Fatalf("bad scan area: %v", m.Area)
+		}
+	})
+}
+
+func TestEncodeResolution(t *testing.T) {
+	m := patternImage(16, 8, 8, false)
+	m.XRes, m.YRes = 300, 150
+	var buf bytes.Buffer
+	if err := EncodePNG(&buf, m); err != nil {
+		t.Fatal("PNG encode failed:", err)
+	}
+	// 300 and 150 dpi are 11811 and 5906 pixels per meter.
+	phys := []byte("pHYs\x00\x00\x2e\x23\x00\x00\x17\x12\x01")
+	if !bytes.Contains(buf.Bytes(), phys) {
+		t.Errorf("PNG has no pHYs chunk")
+	}
+	buf.Reset()
+	if err := EncodeJPEG(&buf, m, nil); err != nil {
+		t.Fatal("JPEG encode failed:", err)
+	}
+	jfif := []byte("JFIF\x00\x01\x02\x01\x01\x2c\x00\x96")
+	if !bytes.Contains(buf.Bytes(), jfif) {
+		t.Errorf("JPEG has no JFIF density")
+	}
+	buf.Reset()
+	if err := EncodeTIFF(&buf, m); err != nil {
+		t.Fatal("TIFF encode failed:", err)
+	}
+	// XResolution is a rational, stored in hundredths.
+	xres := []byte{0x30, 0x75, 0x00, 0x00, 0x64, 0x00, 0x00, 0x00}
+	if !bytes.Contains(buf.Bytes(), xres) {
+		t.Errorf("TIFF has no XResolution")
+	}
+}
+
+func TestTIFFWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewTIFFWriter(&buf)
+	w.Compression = TIFFLZW
+	for _, depth := range []int{1, 8, 16} {
+		if err := w.AddPage(patternImage(13, 5, depth, false)); err != nil {
+			t.Fatal("add page failed:", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("close failed:", err)
+	}
+
+	// Walk the IFD chain, collecting the compression of each page.
+	var compression []int
+	le := binary.LittleEndian
+	data := buf.Bytes()
+	for off := le.Uint32(data[4:]); off != 0; {
+		n := int(le.Uint16(data[off:]))
+		for i := 0; i < n; i++ {
+			e := data[int(off)+2+12*i:]
+			if le.Uint16(e) == tagCompression {
+				compression = append(compression, int(le.Uint16(e[8:])))
+			}
+		}
+		off = le.Uint32(data[int(off)+2+12*n:])
+	}
+	if !reflect.DeepEqual(compression, []int{tiffLZW, tiffLZW, tiffLZW}) {
+		t.Errorf("bad page compression: %v", compression)
+	}
+
+	buf.Reset()
+	gray := &Image{}
+	gray.fs[0] = newFrame(FrameGray, 13, 5, 1, 1, true)
+	if err := EncodeTIFF(&buf, gray); err != nil {
+		t.Fatal("encode failed:", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte{0x03, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, tiffG4}) {
+		t.Errorf("bilevel page not compressed with CCITT Group 4")
+	}
+}
+
+func TestPNM(t *testing.T) {
+	const w, h = 13, 5
+	encode := func(m *Image) []byte {
+		var buf bytes.Buffer
+		if err := m.WritePNM(&buf); err != nil {
+			t.Fatal("encode failed:", err)
+		}
+		return buf.Bytes()
+	}
+
+	gray := &Image{}
+	gray.fs[0] = newFrame(FrameGray, w, h, 1, 1, true)
+	gray.fs[0].data[0] = 0x01 // black pixel at (0,0)
+	b := encode(gray)
+	if hdr := "P4\n13 5\n"; !bytes.HasPrefix(b, []byte(hdr)) || len(b) != len(hdr)+2*h {
+		t.Fatalf("bad PBM output: %q", b)
+	}
+	if b[8] != 0x80 || b[9] != 0 {
+		t.Errorf("bad PBM row: %x", b[8:10])
+	}
+
+	for _, threePass := range []bool{false, true} {
+		for _, depth := range []int{8, 16} {
+			m := patternImage(w, h, depth, threePass)
+			b := encode(m)
+			hdr := fmt.Sprintf("P6\n13 5\n%d\n", 1<<uint(depth)-1)
+			if !bytes.HasPrefix(b, []byte(hdr)) {
+				t.Fatalf("bad PPM header: %q", b)
+			}
+			b = b[len(hdr):]
+			n := depth / 8
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					for ch := 0; ch < 3; ch++ {
+						i := n * (3*(w*y+x) + ch)
+						v := uint16(b[i])
+						if n == 2 {
+							v = v<<8 | uint16(b[i+1])
+						}
+						if want := m.At(x, y); v != pnmSample(want, ch, depth) {
+							t.Fatalf("bad sample at (%d,%d,%d): %d", x, y, ch, v)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	rgbi := &Image{}
+	rgbi.fs[0] = newFrame(FrameRgbi, w, h, 4, 8, true)
+	b = encode(rgbi)
+	hdr := "P7\nWIDTH 13\nHEIGHT 5\nDEPTH 4\nMAXVAL 255\nTUPLTYPE RGB_INFRARED\nENDHDR\n"
+	if !bytes.HasPrefix(b, []byte(hdr)) || len(b) != len(hdr)+4*w*h {
+		t.Errorf("bad PAM output: %q", b)
+	}
+}
+
+// pnmSample returns channel ch of c scaled to the given depth.
+func pnmSample(c color.Color, ch, depth int) uint16 {
+	r, g, b, _ := c.RGBA()
+	v := [3]uint32{r, g, b}[ch]
+	return uint16(v >> uint(16-depth))
+}
+
+func TestNewImage(t *testing.T) {
+	for _, depth := range []int{1, 4, 8, 12, 16} {
+		f, err := NewFrame(FrameRgb, 13, 5, depth)
+		if err != nil {
+			t.Fatal("new frame failed:", err)
+		}
+		max := 1<<uint(depth) - 1
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 13; x++ {
+				for ch := 0; ch < 3; ch++ {
+					f.Set(x, y, ch, uint16((x*7+y*3+ch)%(max+1)))
+				}
+			}
+		}
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 13; x++ {
+				for ch := 0; ch < 3; ch++ {
+					if v := int(f.At(x, y, ch)); v != (x*7+y*3+ch)%(max+1) {
+						t.Fatalf("bad sample at (%d,%d,%d) with depth %d: %d",
+							x, y, ch, depth, v)
+					}
+				}
+			}
+		}
+	}
+
+	g, _ := NewFrame(FrameGray, 4, 4, 1)
+	g.Set(1, 2, 0, 0) // black
+	if g.At(1, 2, 0) != 0 || g.At(0, 0, 0) != 1 {
+		t.Errorf("bad lineart samples")
+	}
+
+	if _, err := NewFrame(FrameJpeg, 4, 4, 8); err == nil {
+		t.Errorf("created frame without samples")
+	}
+	red, _ := NewFrame(FrameRed, 4, 4, 8)
+	green, _ := NewFrame(FrameGreen, 4, 4, 8)
+	blue, _ := NewFrame(FrameBlue, 4, 4, 8)
+	if _, err := NewImage(red, green, blue); err != nil {
+		t.Errorf("three-pass image failed: %v", err)
+	}
+	if _, err := NewImage(red, green); err == nil {
+		t.Errorf("created image with missing frames")
+	}
+	if _, err := NewImage(g, red); err == nil {
+		t.Errorf("created image with mismatched frames")
+	}
+}
+
+func TestCurve(t *testing.T) {
+	g := GammaCurve(2)
+	if v := g.At(0.25); v < 0.49 || v > 0.51 {
+		t.Errorf("gamma 2 maps 0.25 to %v", v)
+	}
+	s := SplineCurve(CurvePoint{0, 0}, CurvePoint{0.5, 0.8}, CurvePoint{1, 1})
+	if v := s.At(0.5); v < 0.79 || v > 0.81 {
+		t.Errorf("spline maps 0.5 to %v", v)
+	}
+	for i := 1; i < len(s); i++ {
+		if s[i] < s[i-1] {
+			t.Fatalf("spline is not monotonic at sample %d", i)
+		}
+	}
+
+	o := &Option{Type: TypeInt, Length: 5, ConstrRange: &Range{0, 1000, 10}}
+	if v := g.Table(o); !reflect.DeepEqual(v, []int{0, 500, 710, 870, 1000}) {
+		t.Errorf("bad gamma table: %v", v)
+	}
+	o = &Option{Type: TypeFloat, Length: 3}
+	if v := SplineCurve().Table(o); !reflect.DeepEqual(v, []float64{0, 1, 2}) {
+		t.Errorf("bad identity table: %v", v)
+	}
+}
+
+func TestGammaTable(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		setOption(t, c, "enable-test-options", true)
+		if _, err := c.SetGammaTable("int-constraint-array", SplineCurve()); err != nil {
+			t.Fatal("set gamma table failed:", err)
+		}
+		if v := getOption(t, c, "int-constraint-array"); !reflect.DeepEqual(v, []int{0, 1, 2, 3, 4, 5}) {
+			t.Errorf("bad gamma table: %v", v)
+		}
+		cv, err := c.GammaTable("int-constraint-array")
+		if err != nil {
+			t.Fatal("get gamma table failed:", err)
+		}
+		if !reflect.DeepEqual(cv, Curve{0, 0.2, 0.4, 0.6, 0.8, 1}) {
+			t.Errorf("bad curve: %v", cv)
+		}
+		if _, err := c.SetOption("int-constraint-array", []int{1, 2}); err == nil {
+			t.Errorf("set table of wrong length succeeded")
+		}
+	})
+}
+
+func TestOptionsDiff(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		if d := c.OptionsDiff(); len(d) != 0 {
+			t.Errorf("changes before setting options: %v", d)
+		}
+		if info := setOption(t, c, "enable-test-options", true); !info.ReloadOpts {
+			t.Fatal("enabling test options did not reload options")
+		}
+		found := false
+		for _, ch := range c.OptionsDiff() {
+			if ch.Name == "enable-test-options" {
+				t.Errorf("option set is reported as changed")
+			}
+			if ch.Name == "int" {
+				found = ch.Activated && !ch.Deactivated
+			}
+		}
+		if !found {
+			t.Errorf("option int not reported as activated")
+		}
+		if d := c.OptionsDiff(); len(d) != 0 {
+			t.Errorf("changes reported twice: %v", d)
+		}
+		deps := c.Dependents("enable-test-options")
+		if len(deps) == 0 || !reflect.DeepEqual(c.DependencyGraph()["enable-test-options"], deps) {
+			t.Errorf("bad dependents: %v", deps)
+		}
+	})
+}
+
+func TestValues(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		vals := c.Values()
+		if vals["int"] != Inactive {
+			t.Errorf("inactive option has value %v", vals["int"])
+		}
+		setOption(t, c, "enable-test-options", true)
+		vals = c.Values()
+		for _, o := range c.Options() {
+			v, ok := vals[o.Name]
+			if !ok || !o.IsActive {
+				continue
+			}
+			if w := getOption(t, c, o.Name); !reflect.DeepEqual(v, w) {
+				t.Errorf("value of %s is %v, should be %v", o.Name, v, w)
+			}
+		}
+		if _, ok := vals["button"]; ok {
+			t.Errorf("button has a value")
+		}
+	})
+}
+
+func TestLookupOption(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		if o, ok := c.LookupOption("mode"); !ok || o.Name != "mode" || o.Type != TypeString {
+			t.Errorf("mode looked up as %+v, %v", o, ok)
+		}
+		if _, ok := c.LookupOption("no-such-option"); ok {
+			t.Error("found a nonexistent option")
+		}
+	})
+}
+
+func TestJSON(t *testing.T) {
+	opts := []Option{
+		{
+			Name:        "resolution",
+			Type:        TypeFloat,
+			Unit:        UnitDpi,
+			Length:      1,
+			ConstrRange: &Range{Min: 50.0, Max: 1200.0, Quant: 0.5},
+			IsActive:    true,
+		},
+		{
+			Name:      "depth",
+			Type:      TypeInt,
+			Unit:      UnitBit,
+			Length:    1,
+			ConstrSet: []interface{}{1, 8, 16},
+		},
+		{
+			Name:      "mode",
+			Type:      TypeString,
+			Length:    1,
+			ConstrSet: []interface{}{"Lineart", "Gray"},
+		},
+	}
+	b, err := json.Marshal(opts)
+	if err != nil {
+		t.Fatal("marshal failed:", err)
+	}
+	if !bytes.Contains(b, []byte(`"type":"float","unit":"dpi"`)) {
+		t.Errorf("enums not encoded as strings: %s", b)
+	}
+	var dec []Option
+	if err := json.Unmarshal(b, &dec); err != nil {
+		t.Fatal("unmarshal failed:", err)
+	}
+	if !reflect.DeepEqual(dec, opts) {
+		t.Errorf("round trip changed options: %+v", dec)
+	}
+
+	p := Params{Format: FrameRgb, IsLast: true, BytesPerLine: 30, PixelsPerLine: 10, Lines: -1, Depth: 8}
+	b, _ = json.Marshal(p)
+	if s := `{"format":"rgb","last":true,"bytesPerLine":30,"pixelsPerLine":10,"lines":-1,"depth":8}`; string(b) != s {
+		t.Errorf("params encoded as %s", b)
+	}
+	var q Params
+	if err := json.Unmarshal(b, &q); err != nil || q != p {
+		t.Errorf("params decoded as %+v: %v", q, err)
+	}
+
+	if s := Format(0x42).String(); s != "Format(66)" {
+		t.Errorf("unknown format is %s", s)
+	}
+	var u Unit
+	if err := json.Unmarshal([]byte(`"furlong"`), &u); err == nil {
+		t.Errorf("unknown unit decoded as %v", u)
+	}
+}
+
+func TestConvertValue(t *testing.T) {
+	o := Option{Name: "x", Type: TypeInt, Length: 3}
+	for _, v := range []interface{}{[]interface{}{1.0, 2.0, 3.0}, []int{1, 2, 3}, []float64{1, 2, 3}} {
+		if cv, err := ConvertValue(o, v); err != nil || !reflect.DeepEqual(cv, []int{1, 2, 3}) {
+			t.Errorf("%v converted to %v (%v)", v, cv, err)
+		}
+	}
+	if _, err := ConvertValue(o, []interface{}{1.0, "a"}); err == nil {
+		t.Error("converted an array with a string to int")
+	}
+	o.Length = 1
+	if _, err := ConvertValue(o, 1.5); err == nil {
+		t.Error("converted 1.5 to int")
+	}
+	o.IsAutomatic = true
+	if v, _ := ConvertValue(o, "auto"); v != Auto {
+		t.Errorf("auto converted to %v", v)
+	}
+	o.Type = TypeFloat
+	if v, _ := ConvertValue(o, 2); v != 2.0 {
+		t.Errorf("2 converted to %v", v)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		setOption(t, c, "mode", "Color")
+		setOption(t, c, "resolution", 100.0)
+		p, err := c.Profile()
+		if err != nil {
+			t.Fatal("get profile failed:", err)
+		}
+		if p["mode"] != "Color" {
+			t.Errorf("profile has mode %v", p["mode"])
+		}
+		b, err := json.Marshal(p)
+		if err != nil {
+			t.Fatal("marshal failed:", err)
+		}
+		var dec Profile
+		if err := json.Unmarshal(b, &dec); err != nil {
+			t.Fatal("unmarshal failed:", err)
+		}
+		setOption(t, c, "mode", "Gray")
+		setOption(t, c, "resolution", 200.0)
+		if err := c.ApplyProfile(dec); err != nil {
+			t.Fatal("apply profile failed:", err)
+		}
+		if q, _ := c.Profile(); !reflect.DeepEqual(p, q) {
+			t.Errorf("profile is %v after applying %v", q, p)
+		}
+		if err := c.ApplyProfile(Profile{"nope": 1}); err == nil {
+			t.Error("applying unknown option succeeded")
+		}
+	})
+}
+
+func TestWatchButtons(t *testing.T) {
+	if !(ButtonEvent{Value: true}).Pressed() || (ButtonEvent{Value: 0}).Pressed() {
+		t.Error("Pressed is wrong")
+	}
+	runTest(t, 1, func(i int, c *Conn) {
+		if _, err := c.WatchButtons(context.Background(), time.Millisecond); err == nil {
+			t.Error("watching a device without sensors succeeded")
+		}
+		setOption(t, c, "enable-test-options", true)
+		found := false
+		for _, o := range c.Sensors() {
+			if o.IsSettable || !o.IsDetectable {
+				t.Errorf("option %s is not a sensor", o.Name)
+			}
+			found = found || o.Name == "bool-soft-detect"
+		}
+		if !found {
+			t.Error("option bool-soft-detect not reported as a sensor")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		ch, err := c.WatchButtons(ctx, time.Millisecond)
+		if err != nil {
+			t.Fatal("watch failed:", err)
+		}
+		for ev := range ch {
+			t.Errorf("unexpected event %+v", ev)
+		}
+		// Polling must not affect OptionsDiff.
+		for _, o := range c.Sensors() {
+			if _, ok := c.known[o.Name]; ok {
+				t.Errorf("value of %s recorded by polling", o.Name)
+			}
+		}
+	})
+}
+
+func TestStatus(t *testing.T) {
+	runTest(t, 1, func(i int, c *Conn) {
+		st, err := c.Status()
+		if err != nil {
+			t.Fatal("get status failed:", err)
+		}
+		if st.PaperLoaded != SensorUnknown || st.CoverOpen != SensorUnknown {
+			t.Errorf("test device reports sensors: %+v", st)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := c.WaitForPaper(ctx); err == nil || err == ctx.Err() {
+			t.Errorf("waiting for paper without a sensor returned %v", err)
+		}
+
+		setOption(t, c, "enable-test-options", true)
+		if st, err = c.Status(); err != nil {
+			t.Fatal("get status failed:", err)
+		}
+		if _, ok := st.Sensors["bool-soft-detect"]; !ok {
+			t.Errorf("status lacks sensor bool-soft-detect: %v", st.Sensors)
+		}
+		b, err := json.Marshal(st)
+		if err != nil {
+			t.Fatal("marshal failed:", err)
+		}
+		if !bytes.Contains(b, []byte(`"paperLoaded":"unknown"`)) {
+			t.Errorf("bad JSON: %s", b)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is a sink that writes files to a local directory.
+//
+// File names may contain slashes, which create subdirectories as needed.
+// Existing files are never replaced: if the name is taken, for instance by a
+// document scanned in the same second, a counter is added to it, as in
+// 2006-01-02-150405-2.pdf. Files are written in place, without hard links or
+// renames, so that any filesystem can be used, including FAT and most
+// network shares.
+type Dir struct {
+	Path string // directory
+	Files
+}
+
+// Send writes the files of d to the directory.
+func (s *Dir) Send(ctx context.Context, d *Document) error {
+	files, err := s.render(d)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(s.Path, filepath.FromSlash(f.name)), f.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes data to the named file, or to the first name not taken
+// made by adding a counter to it. A partially written file is removed.
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	var f *os.File
+	for i := 2; ; i++ {
+		var err error
+		f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return err
+		}
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	_, err := f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(name)
+	}
+	return err
+}
@@ -0,0 +1,171 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"path"
+	"strings"
+	"time"
+)
+
+// Mail is a sink that sends files as email attachments over SMTP.
+//
+// The connection is upgraded with STARTTLS if the server supports it.
+type Mail struct {
+	Addr    string      // server address, as host:port
+	Auth    smtp.Auth   // authentication, or nil for none
+	TLS     *tls.Config // configuration for STARTTLS; nil for the default
+	From    string      // sender address
+	To      []string    // recipient addresses
+	Subject string      // subject, in text/template syntax, executed with a FileInfo for a multi-page file; "Scanned document" if empty
+	Body    string      // plain text body
+	Files
+}
+
+// Send emails the files of d as a single message.
+func (s *Mail) Send(ctx context.Context, d *Document) error {
+	if len(s.To) == 0 {
+		return errors.New("sink: no mail recipients")
+	}
+	files, err := s.render(d)
+	if err != nil {
+		return err
+	}
+	subject := s.Subject
+	if subject == "" {
+		subject = "Scanned document"
+	}
+	subject, err = expand(subject, d)
+	if err != nil {
+		return err
+	}
+	msg, err := s.message(subject, files)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	if t, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(t)
+	}
+	host, _, _ := net.SplitHostPort(s.Addr)
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		cfg := s.TLS
+		if cfg == nil {
+			cfg = &tls.Config{ServerName: host}
+		}
+		if err := c.StartTLS(cfg); err != nil {
+			return err
+		}
+	}
+	if s.Auth != nil {
+		if err := c.Auth(s.Auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(s.From); err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// expand executes a template with the FileInfo of a multi-page file holding
+// d.
+func expand(tmpl string, d *Document) (string, error) {
+	fs := Files{Name: tmpl}
+	var b strings.Builder
+	if err := fs.execute(&b, fs.info(d, 0)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// message builds a MIME message with the body and the files as attachments.
+func (s *Mail) message(subject string, files []file) ([]byte, error) {
+	var b bytes.Buffer
+	mw := multipart.NewWriter(&b)
+	hdr := []struct{ k, v string }{
+		{"From", s.From},
+		{"To", strings.Join(s.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/mixed; boundary=" + mw.Boundary()},
+	}
+	for _, h := range hdr {
+		fmt.Fprintf(&b, "%s: %s\r\n", h.k, h.v)
+	}
+	b.WriteString("\r\n")
+
+	pw, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	pw.Write([]byte(strings.Replace(s.Body, "\n", "\r\n", -1)))
+	for _, f := range files {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {f.mimeType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(f.name)})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64(pw, f.data)
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeBase64 writes data in base64, in lines of 76 characters.
+func writeBase64(w io.Writer, data []byte) {
+	s := base64.StdEncoding.EncodeToString(data)
+	for len(s) > 76 {
+		w.Write([]byte(s[:76] + "\r\n"))
+		s = s[76:]
+	}
+	w.Write([]byte(s + "\r\n"))
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 is a sink that uploads files to a bucket of an S3-compatible object
+// store, such as Amazon S3 or MinIO.
+//
+// Requests use path-style addressing, where the bucket name is the first
+// component of the path, and are signed with AWS Signature Version 4.
+type S3 struct {
+	Endpoint  string       // base URL of the service, such as https://s3.eu-west-1.amazonaws.com
+	Region    string       // region of the bucket; us-east-1 if empty
+	Bucket    string       // bucket name
+	AccessKey string       // access key ID
+	SecretKey string       // secret access key
+	Client    *http.Client // client used for requests; http.DefaultClient if nil
+	Files                  // the file names are the object keys
+}
+
+// Send uploads the files of d as objects.
+func (s *S3) Send(ctx context.Context, d *Document) error {
+	files, err := s.render(d)
+	if err != nil {
+		return err
+	}
+	base, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return err
+	}
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	for _, f := range files {
+		u := *base
+		u.Path += "/" + s.Bucket + "/" + f.name
+		u.RawPath = ""
+		req, err := http.NewRequestWithContext(ctx, "PUT", u.String(), bytes.NewReader(f.data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", f.mimeType)
+		sum := sha256.Sum256(f.data)
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+		sign(req, hex.EncodeToString(sum[:]), s.AccessKey, s.SecretKey, region, "s3", time.Now())
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("sink: cannot upload %s: %s: %s", f.name, resp.Status, bytes.TrimSpace(msg))
+		}
+	}
+	return nil
+}
+
+// awsEscape escapes s as required by Signature Version 4, which leaves only
+// unreserved characters, and optionally slashes, unescaped.
+func awsEscape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '.', c == '_', c == '~', c == '/' && slash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
+
+// sign adds an Authorization header to req with an AWS Signature Version 4
+// for the given time, covering the host and all headers set in req.
+// payloadHash is the hex-encoded SHA-256 hash of the request body.
+func sign(req *http.Request, payloadHash, accessKey, secretKey, region, service string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	var names []string
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, k := range names {
+		canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	q := req.URL.Query()
+	var params []string
+	for k, vs := range q {
+		for _, v := range vs {
+			params = append(params, awsEscape(k, false)+"="+awsEscape(v, false))
+		}
+	}
+	sort.Strings(params)
+
+	path := req.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	canonReq := strings.Join([]string{
+		req.Method,
+		awsEscape(path, true),
+		strings.Join(params, "&"),
+		canonHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	reqHash := sha256.Sum256([]byte(canonReq))
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(reqHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, sig))
+}
@@ -0,0 +1,207 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package sink delivers scanned documents to their destination.
+//
+// A Sink sends a Document, which holds the pages read in one scan job, to a
+// destination such as a local directory (Dir), an email address (Mail), a
+// WebDAV server (WebDAV) or an S3-compatible object store (S3). Each sink
+// encodes the document into files as described by its Files field, which
+// selects the file format and a template for the file names.
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tjgq/sane"
+	"io"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// A Document is the result of a scan job.
+type Document struct {
+	Device string        // name of the device
+	Time   time.Time     // when the scan started
+	Pages  []*sane.Image // scanned pages
+}
+
+// A Sink delivers documents to a destination.
+type Sink interface {
+	Send(ctx context.Context, d *Document) error
+}
+
+// Format is a file format for documents.
+type Format string
+
+// Supported formats. PDF and TIFF files hold all pages of a document, while
+// PNG and JPEG files hold a single page each.
+const (
+	PDF  Format = "pdf"
+	TIFF Format = "tiff"
+	PNG  Format = "png"
+	JPEG Format = "jpeg"
+)
+
+var formats = map[Format]struct {
+	ext       string
+	mimeType  string
+	multipage bool
+}{
+	PDF:  {"pdf", "application/pdf", true},
+	TIFF: {"tif", "image/tiff", true},
+	PNG:  {"png", "image/png", false},
+	JPEG: {"jpg", "image/jpeg", false},
+}
+
+// encode writes the pages in format f.
+func (f Format) encode(w io.Writer, ms []*sane.Image) error {
+	switch f {
+	case PDF:
+		return sane.EncodePDF(w, ms)
+	case TIFF:
+		tw := sane.NewTIFFWriter(w)
+		for _, m := range ms {
+			if err := tw.AddPage(m); err != nil {
+				return err
+			}
+		}
+		return tw.Close()
+	case PNG:
+		return sane.EncodePNG(w, ms[0])
+	case JPEG:
+		return sane.EncodeJPEG(w, ms[0], nil)
+	}
+	return fmt.Errorf("sink: unsupported format %q", f)
+}
+
+// DefaultName is the file name template used when none is given. It names
+// files after the time of the scan, adding the page number for single-page
+// formats.
+const DefaultName = `{{.Date}}{{if .Page}}-{{.Page}}{{end}}.{{.Ext}}`
+
+// FileInfo holds the fields available to file name templates.
+type FileInfo struct {
+	Device string    // device name, with characters other than letters, digits, '.' and '-' replaced by '_'
+	Time   time.Time // when the scan started
+	Date   string    // Time formatted as 2006-01-02-150405
+	Page   int       // page number, from 1, or 0 for multi-page files
+	Pages  int       // number of pages in the document
+	Ext    string    // file name extension for the format, without the dot
+}
+
+// Files describes how a document is encoded into files.
+type Files struct {
+	Format Format // file format; PDF if empty
+	Name   string // file name template, in text/template syntax, executed with a FileInfo; DefaultName if empty
+}
+
+// A file is an encoded document or page.
+type file struct {
+	name     string // slash-separated relative path
+	mimeType string
+	data     []byte
+}
+
+// safeName replaces the characters of s that are unsafe in file names.
+func safeName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// info returns the template fields for the file with the given index.
+func (fs Files) info(d *Document, i int) FileInfo {
+	fi := FileInfo{
+		Device: safeName(d.Device),
+		Time:   d.Time,
+		Date:   d.Time.Format("2006-01-02-150405"),
+		Pages:  len(d.Pages),
+		Ext:    formats[fs.format()].ext,
+	}
+	if !formats[fs.format()].multipage {
+		fi.Page = i + 1
+	}
+	return fi
+}
+
+// format returns the file format, applying the default.
+func (fs Files) format() Format {
+	if fs.Format == "" {
+		return PDF
+	}
+	return fs.Format
+}
+
+// execute executes the name template with the given fields.
+func (fs Files) execute(w io.Writer, fi FileInfo) error {
+	name := fs.Name
+	if name == "" {
+		name = DefaultName
+	}
+	tmpl, err := template.New("name").Parse(name)
+	if err == nil {
+		err = tmpl.Execute(w, fi)
+	}
+	if err != nil {
+		return fmt.Errorf("sink: bad template: %v", err)
+	}
+	return nil
+}
+
+// render encodes the document into files.
+func (fs Files) render(d *Document) ([]file, error) {
+	if len(d.Pages) == 0 {
+		return nil, errors.New("sink: document has no pages")
+	}
+	f := fs.format()
+	info, ok := formats[f]
+	if !ok {
+		return nil, fmt.Errorf("sink: unsupported format %q", f)
+	}
+
+	// A multi-page file holds all pages, and is numbered 0.
+	groups := [][]*sane.Image{d.Pages}
+	if !info.multipage {
+		groups = nil
+		for _, m := range d.Pages {
+			groups = append(groups, []*sane.Image{m})
+		}
+	}
+	var files []file
+	for i, ms := range groups {
+		var nb strings.Builder
+		if err := fs.execute(&nb, fs.info(d, i)); err != nil {
+			return nil, err
+		}
+		n, err := cleanName(nb.String())
+		if err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		if err := f.encode(&b, ms); err != nil {
+			return nil, err
+		}
+		files = append(files, file{n, info.mimeType, b.Bytes()})
+	}
+	return files, nil
+}
+
+// cleanName checks that a file name produced by a template is a relative
+// path that stays within the destination, and returns it in clean form.
+func cleanName(name string) (string, error) {
+	n := path.Clean(name)
+	if name == "" || path.IsAbs(n) || n == "." || n == ".." || strings.HasPrefix(n, "../") {
+		return "", fmt.Errorf("sink: invalid file name %q", name)
+	}
+	return n, nil
+}
@@ -0,0 +1,360 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/tjgq/sane"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// document returns a document with n blank gray pages.
+func document(t *testing.T, n int) *Document {
+	d := &Document{
+		Device: "test:0",
+		Time:   time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC),
+	}
+	for i := 0; i < n; i++ {
+		f, err := sane.NewFrame(sane.FrameGray, 16, 8, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := sane.NewImage(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Pages = append(d.Pages, m)
+	}
+	return d
+}
+
+func TestRender(t *testing.T) {
+	d := document(t, 2)
+	cases := []struct {
+		files Files
+		names []string
+	}{
+		{Files{}, []string{"2015-08-30-123600.pdf"}},
+		{Files{Format: TIFF}, []string{"2015-08-30-123600.tif"}},
+		{Files{Format: PNG}, []string{"2015-08-30-123600-1.png", "2015-08-30-123600-2.png"}},
+		{Files{Format: JPEG, Name: "{{.Device}}/{{.Page}}of{{.Pages}}.{{.Ext}}"}, []string{"test_0/1of2.jpg", "test_0/2of2.jpg"}},
+	}
+	for _, c := range cases {
+		files, err := c.files.render(d)
+		if err != nil {
+			t.Errorf("render %+v failed: %v", c.files, err)
+			continue
+		}
+		var names []string
+		for _, f := range files {
+			names = append(names, f.name)
+		}
+		if strings.Join(names, " ") != strings.Join(c.names, " ") {
+			t.Errorf("render %+v produced %v, expected %v", c.files, names, c.names)
+		}
+	}
+	for _, fs := range []Files{
+		{Format: "gif"},
+		{Name: "../x"},
+		{Name: "/etc/x"},
+		{Name: "{{.Nope}}"},
+	} {
+		if _, err := fs.render(d); err == nil {
+			t.Errorf("render %+v succeeded", fs)
+		}
+	}
+	if _, err := (Files{}).render(document(t, 0)); err == nil {
+		t.Error("render of empty document succeeded")
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	s := &Dir{Path: dir, Files: Files{Format: PNG, Name: "{{.Device}}/p{{.Page}}.{{.Ext}}"}}
+	if err := s.Send(context.Background(), document(t, 2)); err != nil {
+		t.Fatal("send failed:", err)
+	}
+	for _, n := range []string{"p1.png", "p2.png"} {
+		f, err := os.Open(filepath.Join(dir, "test_0", n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := png.Decode(f); err != nil {
+			t.Errorf("bad PNG file %s: %v", n, err)
+		}
+		f.Close()
+	}
+	ents, _ := os.ReadDir(filepath.Join(dir, "test_0"))
+	if len(ents) != 2 {
+		t.Errorf("directory has %d entries, expected 2", len(ents))
+	}
+
+	// Documents scanned in the same second do not replace each other.
+	s = &Dir{Path: dir}
+	d := document(t, 1)
+	for i := 0; i < 3; i++ {
+		if err := s.Send(context.Background(), d); err != nil {
+			t.Fatal("send failed:", err)
+		}
+	}
+	date := d.Time.Format("2006-01-02-150405")
+	for _, n := range []string{date + ".pdf", date + "-2.pdf", date + "-3.pdf"} {
+		if _, err := os.Stat(filepath.Join(dir, n)); err != nil {
+			t.Errorf("file %s not written: %v", n, err)
+		}
+	}
+}
+
+// smtpServer is a minimal SMTP server that accepts one message.
+type smtpServer struct {
+	l    net.Listener
+	done chan struct{}
+	from string
+	to   []string
+	data string
+}
+
+func newSMTPServer(t *testing.T) *smtpServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpServer{l: l, done: make(chan struct{})}
+	go s.serve()
+	return s
+}
+
+func (s *smtpServer) serve() {
+	defer close(s.done)
+	conn, err := s.l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL":
+			s.from = line
+			reply("250 ok")
+		case "RCPT":
+			s.to = append(s.to, line)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var b strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				b.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.data = b.String()
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestMail(t *testing.T) {
+	srv := newSMTPServer(t)
+	defer srv.l.Close()
+	s := &Mail{
+		Addr:    srv.l.Addr().String(),
+		From:    "scanner@example.com",
+		To:      []string{"a@example.com", "b@example.com"},
+		Subject: "Scan from {{.Device}}",
+		Body:    "See attached.",
+		Files:   Files{Format: PNG},
+	}
+	if err := s.Send(context.Background(), document(t, 2)); err != nil {
+		t.Fatal("send failed:", err)
+	}
+	<-srv.done
+	if srv.from != "MAIL FROM:<scanner@example.com>" {
+		t.Errorf("sender is %q", srv.from)
+	}
+	if len(srv.to) != 2 {
+		t.Errorf("message has %d recipients, expected 2", len(srv.to))
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(srv.data))
+	if err != nil {
+		t.Fatal("bad message:", err)
+	}
+	if subj := msg.Header.Get("Subject"); subj != "Scan from test_0" {
+		t.Errorf("subject is %q", subj)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal("bad content type:", err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	var names []string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("bad part:", err)
+		}
+		if p.FileName() == "" {
+			continue
+		}
+		names = append(names, p.FileName())
+		if _, err := png.Decode(base64.NewDecoder(base64.StdEncoding, p)); err != nil {
+			t.Errorf("bad attachment %s: %v", p.FileName(), err)
+		}
+	}
+	if len(names) != 2 {
+		t.Errorf("message has attachments %v, expected 2", names)
+	}
+}
+
+// recorder is an HTTP handler that records the requests it receives.
+type recorder struct {
+	mu   sync.Mutex
+	reqs []string                  // method and path of each request
+	body map[string][]byte         // body of each PUT request, by path
+	fn   func(r *http.Request) int // returns the status code
+}
+
+func (h *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+	h.mu.Lock()
+	h.reqs = append(h.reqs, r.Method+" "+r.URL.Path)
+	if r.Method == "PUT" {
+		h.body[r.URL.Path] = b
+	}
+	h.mu.Unlock()
+	w.WriteHeader(h.fn(r))
+}
+
+func TestWebDAV(t *testing.T) {
+	h := &recorder{body: make(map[string][]byte)}
+	h.fn = func(r *http.Request) int {
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "secret" {
+			return http.StatusUnauthorized
+		}
+		if r.Method == "MKCOL" && r.URL.Path == "/dav/scans/test_0/" {
+			return http.StatusMethodNotAllowed // already exists
+		}
+		return http.StatusCreated
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	s := &WebDAV{
+		URL:      ts.URL + "/dav/scans/",
+		Username: "user",
+		Password: "secret",
+		Files:    Files{Name: "{{.Device}}/a b/{{.Date}}.{{.Ext}}"},
+	}
+	if err := s.Send(context.Background(), document(t, 2)); err != nil {
+		t.Fatal("send failed:", err)
+	}
+	expected := []string{
+		"MKCOL /dav/scans/test_0/",
+		"MKCOL /dav/scans/test_0/a b/",
+		"PUT /dav/scans/test_0/a b/2015-08-30-123600.pdf",
+	}
+	if strings.Join(h.reqs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("requests were %q, expected %q", h.reqs, expected)
+	}
+	if b := h.body["/dav/scans/test_0/a b/2015-08-30-123600.pdf"]; !bytes.HasPrefix(b, []byte("%PDF-")) {
+		t.Error("uploaded file is not a PDF")
+	}
+
+	s.Password = "wrong"
+	if err := s.Send(context.Background(), document(t, 1)); err == nil {
+		t.Error("send with wrong password succeeded")
+	}
+}
+
+func TestS3(t *testing.T) {
+	h := &recorder{body: make(map[string][]byte)}
+	h.fn = func(r *http.Request) int {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			!strings.Contains(auth, "/eu-west-1/s3/aws4_request") {
+			return http.StatusForbidden
+		}
+		b := h.body[r.URL.Path]
+		sum := sha256.Sum256(b)
+		if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) {
+			return http.StatusBadRequest
+		}
+		return http.StatusOK
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	s := &S3{
+		Endpoint:  ts.URL,
+		Region:    "eu-west-1",
+		Bucket:    "scans",
+		AccessKey: "AKID",
+		SecretKey: "secret",
+		Files:     Files{Format: PNG, Name: "inbox/{{.Page}}.{{.Ext}}"},
+	}
+	if err := s.Send(context.Background(), document(t, 2)); err != nil {
+		t.Fatal("send failed:", err)
+	}
+	expected := []string{"PUT /scans/inbox/1.png", "PUT /scans/inbox/2.png"}
+	if strings.Join(h.reqs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("requests were %q, expected %q", h.reqs, expected)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// The get-vanilla case of the AWS Signature Version 4 test suite.
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	sum := sha256.Sum256(nil)
+	sign(req, hex.EncodeToString(sum[:]), "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		"us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("signed with %q, expected %q", auth, expected)
+	}
+}
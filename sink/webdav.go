@@ -0,0 +1,102 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// WebDAV is a sink that uploads files to a WebDAV server.
+//
+// File names may contain slashes, which create collections as needed.
+type WebDAV struct {
+	URL      string       // URL of the destination collection
+	Username string       // user name for basic authentication, or empty for none
+	Password string       // password for basic authentication
+	Client   *http.Client // client used for requests; http.DefaultClient if nil
+	Files
+}
+
+// Send uploads the files of d.
+func (s *WebDAV) Send(ctx context.Context, d *Document) error {
+	files, err := s.render(d)
+	if err != nil {
+		return err
+	}
+	base, err := url.Parse(strings.TrimSuffix(s.URL, "/"))
+	if err != nil {
+		return err
+	}
+	made := make(map[string]bool)
+	for _, f := range files {
+		// Create the parent collections, ignoring the errors reported when
+		// they already exist.
+		dir := path.Dir(f.name)
+		var parts []string
+		if dir != "." {
+			parts = strings.Split(dir, "/")
+		}
+		for i := range parts {
+			p := strings.Join(parts[:i+1], "/")
+			if made[p] {
+				continue
+			}
+			code, err := s.do(ctx, "MKCOL", s.url(base, p+"/"), "", nil)
+			if err != nil {
+				return err
+			}
+			if code != http.StatusCreated && code != http.StatusMethodNotAllowed {
+				return fmt.Errorf("sink: cannot create collection %s: status %d", p, code)
+			}
+			made[p] = true
+		}
+		code, err := s.do(ctx, "PUT", s.url(base, f.name), f.mimeType, f.data)
+		if err != nil {
+			return err
+		}
+		if code < 200 || code > 299 {
+			return fmt.Errorf("sink: cannot upload %s: status %d", f.name, code)
+		}
+	}
+	return nil
+}
+
+// url returns the URL of the resource with the given relative path.
+func (s *WebDAV) url(base *url.URL, name string) string {
+	u := *base
+	u.Path += "/" + name
+	u.RawPath = ""
+	return u.String()
+}
+
+// do performs a request and returns the response status code.
+func (s *WebDAV) do(ctx context.Context, method, url, mimeType string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	if mimeType != "" {
+		req.Header.Set("Content-Type", mimeType)
+	}
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
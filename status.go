@@ -0,0 +1,134 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Interval at which WaitForPaper polls the paper sensor.
+const paperPollInterval = 250 * time.Millisecond
+
+// A SensorState is the state of a device sensor.
+type SensorState int
+
+// Sensor states.
+const (
+	SensorUnknown SensorState = iota // the device has no such sensor
+	SensorOff                        // the sensor is off
+	SensorOn                         // the sensor is on
+)
+
+func (s SensorState) String() string {
+	switch s {
+	case SensorOff:
+		return "off"
+	case SensorOn:
+		return "on"
+	}
+	return "unknown"
+}
+
+// Names of the options used by backends for each sensor, in order of
+// preference.
+var (
+	paperSensors = []string{"page-loaded", "adf-loaded", "document-feeder-loaded", "paper-loaded", "document-loaded"}
+	coverSensors = []string{"cover-open", "adf-open", "lid-open"}
+	lampSensors  = []string{"lamp-on", "lamp-state"}
+	jamSensors   = []string{"paper-jam", "adf-jam", "jam", "double-feed"}
+)
+
+// Status is the state of a device, as reported by its sensor options.
+type Status struct {
+	PaperLoaded SensorState            // paper in the document feeder
+	CoverOpen   SensorState            // cover or feeder open
+	LampOn      SensorState            // lamp on
+	Jammed      SensorState            // paper jam or double feed
+	Sensors     map[string]interface{} // values of all options returned by Sensors
+}
+
+// sensor returns the state of the first active, detectable option among
+// names.
+func (c *Conn) sensor(names []string) (SensorState, error) {
+	for _, name := range names {
+		o := c.findOption(name)
+		if o == nil || !o.IsActive || !o.IsDetectable {
+			continue
+		}
+		v, err := c.readSensor(o)
+		if err != nil {
+			return SensorUnknown, err
+		}
+		switch v := v.(type) {
+		case bool:
+			if v {
+				return SensorOn, nil
+			}
+			return SensorOff, nil
+		case int:
+			if v != 0 {
+				return SensorOn, nil
+			}
+			return SensorOff, nil
+		}
+	}
+	return SensorUnknown, nil
+}
+
+// Status reads the sensor options of the device. Sensors are recognized by
+// the option names used by common backends, such as page-loaded or
+// cover-open; the state of those the device lacks is SensorUnknown.
+func (c *Conn) Status() (Status, error) {
+	var (
+		st  Status
+		err error
+	)
+	for _, s := range []struct {
+		state *SensorState
+		names []string
+	}{
+		{&st.PaperLoaded, paperSensors},
+		{&st.CoverOpen, coverSensors},
+		{&st.LampOn, lampSensors},
+		{&st.Jammed, jamSensors},
+	} {
+		if *s.state, err = c.sensor(s.names); err != nil {
+			return st, err
+		}
+	}
+	st.Sensors = make(map[string]interface{})
+	for _, o := range c.Sensors() {
+		if st.Sensors[o.Name], err = c.readSensor(&o); err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+// WaitForPaper waits until there is paper in the document feeder, polling
+// the paper sensor. It fails if the device has no paper sensor, and returns
+// ctx.Err() if ctx is done first.
+func (c *Conn) WaitForPaper(ctx context.Context) error {
+	t := time.NewTicker(paperPollInterval)
+	defer t.Stop()
+	for {
+		s, err := c.sensor(paperSensors)
+		switch {
+		case err != nil:
+			return err
+		case s == SensorUnknown:
+			return errors.New("sane: device has no paper sensor")
+		case s == SensorOn:
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
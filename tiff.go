@@ -0,0 +1,355 @@
+// Copyright (C) 2013 Tiago Quelhas. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package sane
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// TIFF tag numbers.
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagXResolution     = 282
+	tagYResolution     = 283
+	tagT6Options       = 293
+	tagResolutionUnit  = 296
+)
+
+// TIFF field types.
+const (
+	tiffShort    = 3
+	tiffLong     = 4
+	tiffRational = 5
+)
+
+// TIFF compression schemes.
+const (
+	tiffNone    = 1
+	tiffG4      = 4
+	tiffLZW     = 5
+	tiffDeflate = 8
+)
+
+// TIFF photometric interpretations.
+const (
+	tiffWhiteIsZero = 0
+	tiffBlackIsZero = 1
+	tiffRGB         = 2
+)
+
+// A tiffPage is an image ready to be written to a TIFF file.
+type tiffPage struct {
+	width, height int
+	spp, bps      int     // samples per pixel and bits per sample
+	photometric   int     // photometric interpretation
+	compression   int     // compression scheme
+	xres, yres    float64 // resolution in dots per inch, 0 if unknown
+	data          []byte  // compressed image data, in a single strip
+}
+
+// bitReverse maps a byte to the same byte with its bits in reverse order.
+var bitReverse [256]byte
+
+func init() {
+	for i := range bitReverse {
+		for j := uint(0); j < 8; j++ {
+			if i&(1<<j) != 0 {
+				bitReverse[i] |= 0x80 >> j
+			}
+		}
+	}
+}
+
+// bilevelRows packs a 1-bit grayscale frame into unpadded rows, most
+// significant bit first, with 1 meaning black. This is the same as the raw
+// frame data, except for the bit order and any padding.
+func bilevelRows(f *Frame) []byte {
+	bpl := (f.Width + 7) / 8
+	data := make([]byte, bpl*f.Height)
+	var last byte = 0xff // mask for the last byte in a row
+	if f.Width%8 != 0 {
+		last = 0xff << uint(8-f.Width%8)
+	}
+	for y := 0; y < f.Height; y++ {
+		src := f.data[y*f.bytesPerLine:]
+		dst := data[y*bpl : (y+1)*bpl]
+		for i := range dst {
+			dst[i] = bitReverse[src[i*f.Channels]]
+		}
+		dst[bpl-1] &= last
+	}
+	return data
+}
+
+// tiffSamples returns the uncompressed image data of m in TIFF order, along
+// with the number of samples per pixel, the bits per sample and the
+// photometric interpretation.
+func tiffSamples(m *Image) (data []byte, spp, bps, photometric int) {
+	b := m.Bounds()
+	f := m.frames()[0]
+	switch {
+	case f.Depth == 1 && f.isGray():
+		return bilevelRows(f), 1, 1, tiffWhiteIsZero
+	case f.isGray():
+		spp, photometric = 1, tiffBlackIsZero
+	default:
+		spp, photometric = 3, tiffRGB
+	}
+	bps = 8
+	if f.Depth > 8 {
+		bps = 16
+	}
+	data = make([]byte, 0, b.Dx()*b.Dy()*spp*bps/8)
+	m.rows(b, func(y int, red, green, blue []uint16) {
+		for x := range red {
+			for _, s := range [][]uint16{red, green, blue}[:spp] {
+				if bps == 8 {
+					data = append(data, uint8(s[x]>>8))
+				} else {
+					data = append(data, uint8(s[x]), uint8(s[x]>>8))
+				}
+			}
+		}
+	})
+	return data, spp, bps, photometric
+}
+
+// TIFFCompression is a lossless compression scheme for the grayscale and
+// color pages of a TIFF file. Bilevel pages always use CCITT Group 4.
+type TIFFCompression int
+
+// TIFFCompression constants.
+const (
+	TIFFDeflate TIFFCompression = iota // Deflate (Adobe style)
+	TIFFLZW                            // LZW
+	TIFFNone                           // no compression
+)
+
+// newTIFFPage prepares m to be written to a TIFF file.
+func newTIFFPage(m *Image, c TIFFCompression) (*tiffPage, error) {
+	data, spp, bps, photometric := tiffSamples(m)
+	b := m.Bounds()
+	p := &tiffPage{
+		width:       b.Dx(),
+		height:      b.Dy(),
+		spp:         spp,
+		bps:         bps,
+		photometric: photometric,
+		xres:        m.XRes,
+		yres:        m.YRes}
+	switch {
+	case bps == 1:
+		p.compression, p.data = tiffG4, encodeG4(data, p.width, p.height)
+	case c == TIFFLZW:
+		p.compression, p.data = tiffLZW, encodeLZW(data)
+	case c == TIFFNone:
+		p.compression, p.data = tiffNone, data
+	default:
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		p.compression, p.data = tiffDeflate, z.Bytes()
+	}
+	return p, nil
+}
+
+// A tiffEntry is an IFD entry. Values that do not fit in the entry itself
+// are stored in extra, at the given offset.
+type tiffEntry struct {
+	tag, typ int
+	count    int
+	values   []uint32
+}
+
+func (e tiffEntry) size() int {
+	switch e.typ {
+	case tiffShort:
+		return 2 * e.count
+	case tiffRational:
+		return 8 * e.count
+	}
+	return 4 * e.count
+}
+
+// rational returns the numerator and denominator of a resolution value.
+func rational(f float64) []uint32 {
+	return []uint32{uint32(math.Floor(f*100 + 0.5)), 100}
+}
+
+// entries returns the IFD entries for the page, given the offset of its data.
+func (p *tiffPage) entries(dataOffset int) []tiffEntry {
+	bps := make([]uint32, p.spp)
+	for i := range bps {
+		bps[i] = uint32(p.bps)
+	}
+	es := []tiffEntry{
+		{tagImageWidth, tiffLong, 1, []uint32{uint32(p.width)}},
+		{tagImageLength, tiffLong, 1, []uint32{uint32(p.height)}},
+		{tagBitsPerSample, tiffShort, p.spp, bps},
+		{tagCompression, tiffShort, 1, []uint32{uint32(p.compression)}},
+		{tagPhotometric, tiffShort, 1, []uint32{uint32(p.photometric)}},
+		{tagStripOffsets, tiffLong, 1, []uint32{uint32(dataOffset)}},
+		{tagSamplesPerPixel, tiffShort, 1, []uint32{uint32(p.spp)}},
+		{tagRowsPerStrip, tiffLong, 1, []uint32{uint32(p.height)}},
+		{tagStripByteCounts, tiffLong, 1, []uint32{uint32(len(p.data))}},
+	}
+	if p.compression == tiffG4 {
+		es = append(es, tiffEntry{tagT6Options, tiffLong, 1, []uint32{0}})
+	}
+	if p.xres > 0 && p.yres > 0 {
+		es = append(es,
+			tiffEntry{tagXResolution, tiffRational, 1, rational(p.xres)},
+			tiffEntry{tagYResolution, tiffRational, 1, rational(p.yres)},
+			tiffEntry{tagResolutionUnit, tiffShort, 1, []uint32{2}}) // inches
+	}
+	sort.Slice(es, func(i, j int) bool { return es[i].tag < es[j].tag })
+	return es
+}
+
+// appendUint16 appends v to b in little-endian order.
+func appendUint16(b []byte, v uint16) []byte {
+	var x [2]byte
+	binary.LittleEndian.PutUint16(x[:], v)
+	return append(b, x[:]...)
+}
+
+// appendUint32 appends v to b in little-endian order.
+func appendUint32(b []byte, v uint32) []byte {
+	var x [4]byte
+	binary.LittleEndian.PutUint32(x[:], v)
+	return append(b, x[:]...)
+}
+
+// writeTIFF writes a TIFF file holding the given pages. Each page is laid out
+// as its IFD, followed by the IFD values that do not fit in their entries,
+// followed by the image data.
+func writeTIFF(w io.Writer, pages []*tiffPage) error {
+	bw := bufio.NewWriter(w)
+	header := []byte{'I', 'I', 42, 0, 8, 0, 0, 0} // first IFD follows the header
+	if _, err := bw.Write(header); err != nil {
+		return err
+	}
+	off := len(header)
+	var buf []byte
+	for i, p := range pages {
+		// Compute the layout of the page.
+		n := len(p.entries(0))
+		extraOff := off + 2 + 12*n + 4
+		extraLen := 0
+		for _, e := range p.entries(0) {
+			if e.size() > 4 {
+				extraLen += e.size()
+			}
+		}
+		dataOff := extraOff + extraLen
+		next := 0
+		if i < len(pages)-1 {
+			next = dataOff + len(p.data)
+			next += next % 2 // IFDs must start on a word boundary
+		}
+
+		// Write the IFD and its extra values.
+		es := p.entries(dataOff)
+		var extra []byte
+		buf = appendUint16(buf, uint16(len(es)))
+		for _, e := range es {
+			buf = appendUint16(buf, uint16(e.tag))
+			buf = appendUint16(buf, uint16(e.typ))
+			buf = appendUint32(buf, uint32(e.count))
+			var v []byte
+			for _, x := range e.values {
+				if e.typ == tiffShort {
+					v = appendUint16(v, uint16(x))
+				} else {
+					v = appendUint32(v, x)
+				}
+			}
+			if len(v) > 4 {
+				buf = appendUint32(buf, uint32(extraOff+len(extra)))
+				extra = append(extra, v...)
+			} else {
+				buf = append(buf, v...)
+				buf = append(buf, make([]byte, 4-len(v))...)
+			}
+		}
+		buf = appendUint32(buf, uint32(next))
+		buf = append(buf, extra...)
+		buf = append(buf, p.data...)
+		if next > 0 && len(p.data)%2 != 0 {
+			buf = append(buf, 0)
+		}
+		if _, err := bw.Write(buf); err != nil {
+			return err
+		}
+		off += len(buf)
+		buf = buf[:0]
+	}
+	return bw.Flush()
+}
+
+// A TIFFWriter writes images as the pages of a TIFF file. Pages are kept in
+// memory, compressed, until Close is called.
+type TIFFWriter struct {
+	Compression TIFFCompression // compression for grayscale and color pages
+	w           io.Writer
+	pages       []*tiffPage
+}
+
+// NewTIFFWriter returns a TIFFWriter that writes to w, using Deflate
+// compression for grayscale and color pages.
+func NewTIFFWriter(w io.Writer) *TIFFWriter {
+	return &TIFFWriter{w: w}
+}
+
+// AddPage appends an image to the file.
+func (t *TIFFWriter) AddPage(m *Image) error {
+	p, err := newTIFFPage(m, t.Compression)
+	if err != nil {
+		return err
+	}
+	t.pages = append(t.pages, p)
+	return nil
+}
+
+// Close writes the file. It does not close the underlying writer.
+func (t *TIFFWriter) Close() error {
+	if len(t.pages) == 0 {
+		return errors.New("sane: TIFF file has no pages")
+	}
+	err := writeTIFF(t.w, t.pages)
+	t.pages = nil
+	return err
+}
+
+// EncodeTIFF writes the image to w in TIFF format, using lossless
+// compression. If the image's resolution is known, it is recorded in the
+// XResolution and YResolution tags.
+func EncodeTIFF(w io.Writer, m *Image) error {
+	t := NewTIFFWriter(w)
+	if err := t.AddPage(m); err != nil {
+		return err
+	}
+	return t.Close()
+}